@@ -0,0 +1,319 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	BalanceRoundRobin = "round-robin" // pick the backends in turn
+	BalanceLeastConn  = "least-conn"  // pick the backend with the least active connections
+	BalanceCookieHash = "cookie-hash" // pick the backend by the consistent hash of a cookie
+
+	defaultHashCookie          = "SESSION"
+	defaultFailTimeout         = time.Second * 30
+	defaultHealthCheckTimeout  = time.Second * 5
+	defaultHealthCheckInterval = time.Second * 10
+	hashRingReplicas           = 100
+)
+
+var errNoAvailableBackend = errors.New("no available backend")
+
+// UpstreamPool is a group of backends which serve the same content.
+type UpstreamPool struct {
+	Targets     []*url.URL    // the backends
+	Strategy    string        // load balance strategy, defaults to round-robin
+	HashCookie  string        // the cookie name used by the cookie-hash strategy, defaults to SESSION
+	FailTimeout time.Duration // how long a backend stays ejected after a dial failure when health check is disabled, defaults to 30s
+	HealthCheck *HealthCheck  // active health check, disabled if nil
+}
+
+// HealthCheck sends request to the backends periodically, and ejects the backend which fails.
+type HealthCheck struct {
+	Path               string        // the path to request, eg. '/healthz'
+	Interval           time.Duration // the interval between checks, defaults to 10s
+	Timeout            time.Duration // the timeout of each check, defaults to 5s
+	HealthyThreshold   int           // the number of consecutive successes to restore an ejected backend, defaults to 1
+	UnhealthyThreshold int           // the number of consecutive failures to eject a backend, defaults to 1
+}
+
+type backend struct {
+	target    *url.URL
+	down      int32 // 1 if the backend is ejected
+	conns     int64 // active connections
+	successes int   // consecutive successes of health check
+	failures  int   // consecutive failures of health check
+	ejectedAt time.Time
+	mu        sync.Mutex
+}
+
+func (b *backend) isAvailable(failTimeout time.Duration) bool {
+	if atomic.LoadInt32(&b.down) == 0 {
+		return true
+	}
+
+	// the backend ejected passively is restored after timeout when health check is disabled
+	if failTimeout > 0 {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if time.Since(b.ejectedAt) >= failTimeout {
+			atomic.StoreInt32(&b.down, 0)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *backend) eject(reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ejectedAt = time.Now()
+	b.successes = 0
+
+	if atomic.SwapInt32(&b.down, 1) == 0 {
+		log.Printf("eject backend '%s': %s", b.target.String(), reason)
+	}
+}
+
+func (b *backend) restore() {
+	if atomic.SwapInt32(&b.down, 0) == 1 {
+		log.Printf("restore backend '%s'", b.target.String())
+	}
+}
+
+type hashRingNode struct {
+	hash    uint32
+	backend *backend
+}
+
+type upstream struct {
+	*UpstreamPool
+	backends []*backend
+	ring     []hashRingNode
+	next     uint32
+	cancel   context.CancelFunc
+}
+
+func newUpstream(pool *UpstreamPool) *upstream {
+	u := &upstream{
+		UpstreamPool: pool,
+	}
+
+	for _, target := range pool.Targets {
+		b := &backend{target: target}
+
+		u.backends = append(u.backends, b)
+
+		for i := 0; i < hashRingReplicas; i++ {
+			u.ring = append(u.ring, hashRingNode{
+				hash:    crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s#%d", target.String(), i))),
+				backend: b,
+			})
+		}
+	}
+
+	sort.Slice(u.ring, func(i, j int) bool {
+		return u.ring[i].hash < u.ring[j].hash
+	})
+
+	return u
+}
+
+func (u *upstream) failTimeout() time.Duration {
+	if u.HealthCheck != nil {
+		// restored by health check
+		return 0
+	}
+
+	if u.FailTimeout > 0 {
+		return u.FailTimeout
+	}
+
+	return defaultFailTimeout
+}
+
+func (u *upstream) pick(r *http.Request) (*backend, error) {
+	failTimeout := u.failTimeout()
+
+	switch u.Strategy {
+	case BalanceLeastConn:
+		var picked *backend
+
+		for _, b := range u.backends {
+			if !b.isAvailable(failTimeout) {
+				continue
+			}
+
+			if picked == nil || atomic.LoadInt64(&b.conns) < atomic.LoadInt64(&picked.conns) {
+				picked = b
+			}
+		}
+
+		if picked != nil {
+			return picked, nil
+		}
+
+		return nil, errNoAvailableBackend
+	case BalanceCookieHash:
+		cookieName := u.HashCookie
+
+		if cookieName == "" {
+			cookieName = defaultHashCookie
+		}
+
+		if cookie, err := r.Cookie(cookieName); err == nil && cookie.Value != "" {
+			hash := crc32.ChecksumIEEE([]byte(cookie.Value))
+			index := sort.Search(len(u.ring), func(i int) bool {
+				return u.ring[i].hash >= hash
+			})
+
+			// walk the ring clockwise until an available backend is found
+			for i := 0; i < len(u.ring); i++ {
+				node := u.ring[(index+i)%len(u.ring)]
+
+				if node.backend.isAvailable(failTimeout) {
+					return node.backend, nil
+				}
+			}
+
+			return nil, errNoAvailableBackend
+		}
+
+		// the client without cookie falls back to round-robin
+		fallthrough
+	case BalanceRoundRobin:
+		fallthrough
+	default:
+		for i := 0; i < len(u.backends); i++ {
+			b := u.backends[int(atomic.AddUint32(&u.next, 1)-1)%len(u.backends)]
+
+			if b.isAvailable(failTimeout) {
+				return b, nil
+			}
+		}
+
+		return nil, errNoAvailableBackend
+	}
+}
+
+// reportError ejects the backend passively if it can not be dialed
+func (u *upstream) reportError(b *backend, err error) {
+	var opErr *net.OpError
+
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		b.eject(err.Error())
+	}
+}
+
+func (u *upstream) startHealthCheck(client *http.Client) {
+	if u.HealthCheck == nil {
+		return
+	}
+
+	interval := u.HealthCheck.Interval
+
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	u.cancel = cancel
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			for _, b := range u.backends {
+				go u.check(ctx, client, b)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (u *upstream) stopHealthCheck() {
+	if u.cancel != nil {
+		u.cancel()
+	}
+}
+
+func (u *upstream) check(ctx context.Context, client *http.Client, b *backend) {
+	timeout := u.HealthCheck.Timeout
+
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	target := *b.target
+	target.Path = singleJoiningSlash(target.Path, u.HealthCheck.Path)
+
+	healthy := false
+	reason := ""
+
+	if req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil); err != nil {
+		reason = err.Error()
+	} else if res, err := client.Do(req); err != nil {
+		reason = err.Error()
+	} else {
+		_ = res.Body.Close()
+
+		healthy = res.StatusCode >= 200 && res.StatusCode < 400
+		reason = fmt.Sprintf("health check responds with status code %d", res.StatusCode)
+	}
+
+	if ctx.Err() == context.Canceled {
+		return
+	}
+
+	b.mu.Lock()
+
+	if healthy {
+		b.successes++
+		b.failures = 0
+	} else {
+		b.failures++
+		b.successes = 0
+	}
+
+	successes, failures := b.successes, b.failures
+
+	b.mu.Unlock()
+
+	if healthy && successes >= maxInt(u.HealthCheck.HealthyThreshold, 1) {
+		b.restore()
+	} else if !healthy && failures >= maxInt(u.HealthCheck.UnhealthyThreshold, 1) {
+		b.eject(reason)
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
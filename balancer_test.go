@@ -0,0 +1,108 @@
+package forward
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_upstream_pick(t *testing.T) {
+	targets := []*url.URL{}
+
+	for _, host := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		targets = append(targets, &url.URL{Scheme: "http", Host: host})
+	}
+
+	t.Run("round-robin skips the ejected backend", func(t *testing.T) {
+		u := newUpstream(&UpstreamPool{Targets: targets, HealthCheck: &HealthCheck{}})
+		u.backends[1].eject("test")
+
+		got := []string{}
+
+		for i := 0; i < 4; i++ {
+			b, err := u.pick(httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got = append(got, b.target.Host)
+		}
+
+		want := []string{"a.example.com", "c.example.com", "a.example.com", "c.example.com"}
+
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("pick() = %v, want %v", got, want)
+			}
+		}
+	})
+
+	t.Run("least-conn", func(t *testing.T) {
+		u := newUpstream(&UpstreamPool{Targets: targets, Strategy: BalanceLeastConn})
+		u.backends[0].conns = 2
+		u.backends[1].conns = 1
+		u.backends[2].conns = 3
+
+		if b, _ := u.pick(httptest.NewRequest(http.MethodGet, "/", nil)); b != u.backends[1] {
+			t.Errorf("pick() = %v, want %v", b.target, u.backends[1].target)
+		}
+	})
+
+	t.Run("cookie-hash is sticky", func(t *testing.T) {
+		u := newUpstream(&UpstreamPool{Targets: targets, Strategy: BalanceCookieHash, HashCookie: "sid"})
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: "sid", Value: "user-1"})
+
+		first, _ := u.pick(r)
+
+		for i := 0; i < 10; i++ {
+			if b, _ := u.pick(r); b != first {
+				t.Fatalf("pick() = %v, want %v", b.target, first.target)
+			}
+		}
+
+		// fail over to another backend when the picked one is ejected
+		first.eject("test")
+
+		if b, _ := u.pick(r); b == nil || b == first {
+			t.Errorf("pick() should fail over to another backend")
+		}
+	})
+
+	t.Run("no available backend", func(t *testing.T) {
+		u := newUpstream(&UpstreamPool{Targets: targets[:1], HealthCheck: &HealthCheck{}})
+		u.backends[0].eject("test")
+
+		if _, err := u.pick(httptest.NewRequest(http.MethodGet, "/", nil)); err != errNoAvailableBackend {
+			t.Errorf("pick() error = %v, want %v", err, errNoAvailableBackend)
+		}
+	})
+}
+
+func Test_upstream_startHealthCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	target, _ := url.Parse(server.URL)
+
+	// the interval defaults when it is not specified, so the ejected backend is restored
+	u := newUpstream(&UpstreamPool{Targets: []*url.URL{target}, HealthCheck: &HealthCheck{Path: "/healthz"}})
+	u.backends[0].eject("test")
+
+	u.startHealthCheck(server.Client())
+	defer u.stopHealthCheck()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !u.backends[0].isAvailable(u.failTimeout()) {
+		if time.Now().After(deadline) {
+			t.Fatal("the backend is not restored by health check")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,253 @@
+package forward
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	caCertFileName = "ca.crt"
+	caKeyFileName  = "ca.key"
+	// the browsers reject the certificate which is valid for more than 398 days
+	leafCertValidity = 365 * 24 * time.Hour
+	caCertValidity   = 10 * 365 * 24 * time.Hour
+)
+
+// LocalCA is a root certificate authority persisted in a folder, it issues the certificates for any server name on the fly.
+// The certificates are trusted once the root certificate is trusted by system.
+type LocalCA struct {
+	dir     string
+	cert    *x509.Certificate
+	key     crypto.Signer
+	created bool
+	leaves  map[string]*tls.Certificate
+	mu      sync.Mutex
+}
+
+// NewLocalCA loads the root certificate in the folder, it is created if not exists
+func NewLocalCA(dir string) (*LocalCA, error) {
+	ca := &LocalCA{
+		dir:    dir,
+		leaves: map[string]*tls.Certificate{},
+	}
+
+	certPEM, err := ioutil.ReadFile(ca.CertFile())
+
+	if os.IsNotExist(err) {
+		if err := ca.create(); err != nil {
+			return nil, err
+		}
+
+		return ca, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	keyPEM, err := ioutil.ReadFile(filepath.Join(dir, caKeyFileName))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid root certificate in '%s'", dir)
+	}
+
+	if ca.cert, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+
+	if !ok {
+		return nil, errors.Errorf("invalid private key of root certificate in '%s'", dir)
+	}
+
+	ca.key = signer
+
+	return ca, nil
+}
+
+// CertFile returns the path of root certificate, it should be trusted by system and browsers
+func (ca *LocalCA) CertFile() string {
+	return filepath.Join(ca.dir, caCertFileName)
+}
+
+// Created reports whether the root certificate is created by NewLocalCA
+func (ca *LocalCA) Created() bool {
+	return ca.created
+}
+
+func (ca *LocalCA) create() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	serialNumber, err := randomSerialNumber()
+
+	if err != nil {
+		return err
+	}
+
+	hostName, _ := os.Hostname()
+
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"forward-cli"},
+			CommonName:   strings.TrimSpace("forward-cli local CA " + hostName),
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caCertValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := os.MkdirAll(ca.dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// the private key can issue the certificates trusted by system, it is readable by the owner only
+	if err := ioutil.WriteFile(filepath.Join(ca.dir, caKeyFileName), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := ioutil.WriteFile(ca.CertFile(), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if ca.cert, err = x509.ParseCertificate(der); err != nil {
+		return errors.WithStack(err)
+	}
+
+	ca.key = key
+	ca.created = true
+
+	return nil
+}
+
+// GetCertificate issues the certificate for the server name of client, it is used as tls.Config.GetCertificate.
+// the IP address of connection is used if the client does not send the server name.
+func (ca *LocalCA) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	if name == "" {
+		name = "localhost"
+
+		if hello.Conn != nil {
+			if addr, ok := hello.Conn.LocalAddr().(*net.TCPAddr); ok {
+				name = addr.IP.String()
+			}
+		}
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if cert, ok := ca.leaves[name]; ok && time.Now().Before(cert.Leaf.NotAfter.Add(-time.Hour)) {
+		return cert, nil
+	}
+
+	cert, err := ca.issue(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ca.leaves[name] = cert
+
+	return cert, nil
+}
+
+// issue creates a certificate signed by the root certificate
+func (ca *LocalCA) issue(name string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	serialNumber, err := randomSerialNumber()
+
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"forward-cli"},
+			CommonName:   name,
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(leafCertValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func randomSerialNumber() (*big.Int, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+
+	return serialNumber, errors.WithStack(err)
+}
@@ -0,0 +1,70 @@
+package forward
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLocalCA_GetCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, err := NewLocalCA(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ca.Created() {
+		t.Errorf("the root certificate should be created")
+	}
+
+	// the root certificate is persisted
+	ca, err = NewLocalCA(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ca.Created() {
+		t.Errorf("the root certificate should be loaded")
+	}
+
+	b, err := ioutil.ReadFile(ca.CertFile())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(b)
+
+	tests := []struct {
+		serverName string
+		verify     string
+	}{
+		{serverName: "example.test", verify: "example.test"},
+		{serverName: "App.Example.Test.", verify: "app.example.test"},
+		{serverName: "", verify: "localhost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			cert, err := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: tt.verify, Roots: roots}); err != nil {
+				t.Errorf("the certificate is not trusted: %s", err)
+			}
+
+			cached, _ := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+
+			if cached != cert {
+				t.Errorf("the certificate should be cached")
+			}
+		})
+	}
+}
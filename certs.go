@@ -0,0 +1,213 @@
+package forward
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// the editors and the tools like certbot write the cert and key one after another
+const certReloadDebounce = 500 * time.Millisecond
+
+// CertPair is the files of a certificate and its private key
+type CertPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// CertReloader serves the certificates by the server name of client, it reloads them without restarting the server.
+// The first certificate is used if no certificate matches the server name.
+type CertReloader struct {
+	pairs   []CertPair
+	certs   []*tls.Certificate
+	names   map[string]*tls.Certificate
+	watcher *fsnotify.Watcher
+	mu      sync.RWMutex
+}
+
+// NewCertReloader loads the certificates
+func NewCertReloader(pairs []CertPair) (*CertReloader, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("the certificates can not be empty")
+	}
+
+	r := &CertReloader{pairs: pairs}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload loads the certificates from files, the loaded certificates are kept if any file is invalid
+func (r *CertReloader) Reload() error {
+	certs := make([]*tls.Certificate, 0, len(r.pairs))
+	names := map[string]*tls.Certificate{}
+
+	for _, pair := range r.pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+
+		if err != nil {
+			return errors.Wrapf(err, "failed to load the certificate '%s'", pair.CertFile)
+		}
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse the certificate '%s'", pair.CertFile)
+		}
+
+		cert.Leaf = leaf
+		certs = append(certs, &cert)
+
+		for _, name := range certNames(leaf) {
+			// the former certificate takes precedence
+			if _, ok := names[name]; !ok {
+				names[name] = &cert
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.certs = certs
+	r.names = names
+	r.mu.Unlock()
+
+	return nil
+}
+
+// certNames returns the lower case names of certificate, the common name is used only if there is no SAN
+func certNames(leaf *x509.Certificate) []string {
+	var names []string
+
+	for _, name := range leaf.DNSNames {
+		names = append(names, strings.ToLower(name))
+	}
+
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = append(names, strings.ToLower(leaf.Subject.CommonName))
+	}
+
+	return names
+}
+
+// GetCertificate returns the certificate of the server name, it is used as tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if cert, ok := r.names[name]; ok {
+		return cert, nil
+	}
+
+	// the wildcard matches a single label, eg. '*.example.com' matches 'a.example.com'
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := r.names["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+
+	if name == "" && hello.Conn != nil {
+		if addr, ok := hello.Conn.LocalAddr().(*net.TCPAddr); ok {
+			if cert, ok := r.names[addr.IP.String()]; ok {
+				return cert, nil
+			}
+		}
+	}
+
+	return r.certs[0], nil
+}
+
+// Watch reloads the certificates when the files change
+func (r *CertReloader) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	files := map[string]struct{}{}
+
+	for _, pair := range r.pairs {
+		for _, f := range []string{pair.CertFile, pair.KeyFile} {
+			abs, err := filepath.Abs(f)
+
+			if err != nil {
+				_ = watcher.Close()
+				return errors.WithStack(err)
+			}
+
+			files[abs] = struct{}{}
+
+			// watch the directory, because the file may be replaced, eg. the symlinks of certbot
+			if err := watcher.Add(filepath.Dir(abs)); err != nil {
+				_ = watcher.Close()
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	r.watcher = watcher
+
+	go func() {
+		var timer *time.Timer
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if _, ok := files[event.Name]; !ok {
+					continue
+				}
+
+				if timer != nil {
+					timer.Stop()
+				}
+
+				timer = time.AfterFunc(certReloadDebounce, func() {
+					if err := r.Reload(); err != nil {
+						log.Printf("failed to reload the certificates: %+v\n", err)
+						return
+					}
+
+					log.Println("The certificates are reloaded")
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				log.Printf("failed to watch the certificates: %+v\n", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops watching the files
+func (r *CertReloader) Close() error {
+	if r.watcher == nil {
+		return nil
+	}
+
+	return errors.WithStack(r.watcher.Close())
+}
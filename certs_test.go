@@ -0,0 +1,122 @@
+package forward
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertPair issues a certificate for the name and writes it to the folder
+func writeCertPair(t *testing.T, ca *LocalCA, dir string, name string, fileName string) CertPair {
+	cert, err := ca.issue(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair := CertPair{
+		CertFile: filepath.Join(dir, fileName+".crt"),
+		KeyFile:  filepath.Join(dir, fileName+".key"),
+	}
+
+	if err := ioutil.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return pair
+}
+
+func TestCertReloader_GetCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, err := NewLocalCA(filepath.Join(dir, "ca"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloader, err := NewCertReloader([]CertPair{
+		writeCertPair(t, ca, dir, "a.test", "a"),
+		writeCertPair(t, ca, dir, "*.b.test", "b"),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{serverName: "a.test", want: "a.test"},
+		{serverName: "x.b.test", want: "*.b.test"},
+		{serverName: "x.y.b.test", want: "a.test"},
+		{serverName: "", want: "a.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			cert, err := reloader.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := cert.Leaf.Subject.CommonName; got != tt.want {
+				t.Errorf("GetCertificate() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertReloader_Watch(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, err := NewLocalCA(filepath.Join(dir, "ca"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloader, err := NewCertReloader([]CertPair{writeCertPair(t, ca, dir, "a.test", "a")})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reloader.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	hello := &tls.ClientHelloInfo{ServerName: "c.test"}
+	old, _ := reloader.GetCertificate(hello)
+
+	// renew the certificate with another name
+	writeCertPair(t, ca, dir, "c.test", "a")
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if cert, _ := reloader.GetCertificate(hello); cert != old {
+			if cert.Leaf.Subject.CommonName != "c.test" {
+				t.Errorf("GetCertificate() = %s, want c.test", cert.Leaf.Subject.CommonName)
+			}
+
+			return
+		}
+	}
+
+	t.Errorf("the certificate is not reloaded")
+}
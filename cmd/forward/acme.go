@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeOptions is the options of ACME client which provisions the certificates automatically
+type acmeOptions struct {
+	Domains      []string // the host names to request certificates for, the others are rejected
+	Email        string   // the contact email of account, for the notices of expiration
+	CacheDir     string   // the folder to persist the account key and certificates
+	DirectoryURL string   // the directory of ACME server. defaults to Let's Encrypt
+	CAFile       string   // trust the certificate of ACME server, eg. the root of Pebble
+}
+
+// newACMEManager creates the manager which requests and renews the certificates.
+// the TLS-ALPN-01 challenge is served by its GetCertificate, the HTTP-01 challenge by its HTTPHandler.
+func newACMEManager(options *acmeOptions) (*autocert.Manager, error) {
+	if len(options.Domains) == 0 {
+		return nil, errors.New("the domains of ACME can not be empty")
+	}
+
+	cacheDir := options.CacheDir
+
+	if cacheDir == "" {
+		dir, err := os.UserConfigDir()
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		cacheDir = filepath.Join(dir, "forward-cli", "acme")
+	}
+
+	client := &acme.Client{
+		DirectoryURL: options.DirectoryURL,
+	}
+
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+
+	if options.CAFile != "" {
+		roots, err := loadCertPool([]string{options.CAFile})
+
+		if err != nil {
+			return nil, err
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(options.Domains...),
+		Email:      options.Email,
+		Client:     client,
+	}, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func Test_newACMEManager(t *testing.T) {
+	dir := t.TempDir()
+	invalidCAFile := filepath.Join(dir, "ca.pem")
+
+	if err := ioutil.WriteFile(invalidCAFile, []byte("invalid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		options *acmeOptions
+		wantErr bool
+	}{
+		{name: "no domain", options: &acmeOptions{CacheDir: dir}, wantErr: true},
+		{name: "invalid ca file", options: &acmeOptions{Domains: []string{"example.com"}, CacheDir: dir, CAFile: invalidCAFile}, wantErr: true},
+		{name: "default directory", options: &acmeOptions{Domains: []string{"example.com"}, CacheDir: dir}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, err := newACMEManager(tt.options)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newACMEManager() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if manager.Client.DirectoryURL != autocert.DefaultACMEDirectory {
+				t.Errorf("DirectoryURL = %s", manager.Client.DirectoryURL)
+			}
+
+			if err := manager.HostPolicy(context.Background(), "example.com"); err != nil {
+				t.Errorf("the domain should be allowed: %s", err)
+			}
+
+			if err := manager.HostPolicy(context.Background(), "other.com"); err == nil {
+				t.Errorf("the other domain should be rejected")
+			}
+		})
+	}
+}
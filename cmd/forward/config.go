@@ -0,0 +1,493 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	forward "github.com/axetroy/forward-cli"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// config is the content of the file specified by '--config=<file>'.
+// YAML and JSON are both accepted because JSON is a subset of YAML.
+type config struct {
+	poolConfig           `yaml:",inline"`
+	Target               string            `yaml:"target"`
+	Address              string            `yaml:"address"`
+	Port                 string            `yaml:"port"`
+	ListenProtocol       string            `yaml:"listen-protocol"`
+	UpstreamProtocol     string            `yaml:"upstream-protocol"`
+	GRPCWeb              *bool             `yaml:"grpc-web"`
+	ReqHeaders           map[string]string `yaml:"req-headers"`
+	ResHeaders           map[string]string `yaml:"res-headers"`
+	Cors                 *bool             `yaml:"cors"`
+	NoCache              *bool             `yaml:"no-cache"`
+	Overwrite            string            `yaml:"overwrite"`
+	OverwriteFallback    string            `yaml:"overwrite-fallback"`
+	LiveReload           *bool             `yaml:"live-reload"`
+	ProxyExternal        *bool             `yaml:"proxy-external"`
+	ProxyExternalIgnores []string          `yaml:"proxy-external-ignores"`
+	MaxRewriteSize       int64             `yaml:"max-rewrite-size"`
+	FlushInterval        time.Duration     `yaml:"flush-interval"`
+	RewriteSSE           *bool             `yaml:"rewrite-sse"`
+	Script               string            `yaml:"script"`
+	HAR                  string            `yaml:"har"`
+	HARMaxBodySize       int               `yaml:"har-max-body-size"`
+	Inspect              string            `yaml:"inspect"`
+	InspectSize          int               `yaml:"inspect-size"`
+	InspectMaxBodySize   int               `yaml:"inspect-max-body-size"`
+	Record               string            `yaml:"record"`
+	Replay               string            `yaml:"replay"`
+	ReplayFallback       string            `yaml:"replay-fallback"`
+	RecordMatchBody      *bool             `yaml:"record-match-body"`
+	WSLog                string            `yaml:"ws-log"`
+	WSIdleTimeout        time.Duration     `yaml:"ws-idle-timeout"`
+	WSPingInterval       time.Duration     `yaml:"ws-ping-interval"`
+	TLSCertFile          string            `yaml:"tls-cert-file"`
+	TLSKeyFile           string            `yaml:"tls-key-file"`
+	Certificates         []certConfig      `yaml:"certificates"`
+	TLSAuto              *bool             `yaml:"tls-auto"`
+	TLSCADir             string            `yaml:"tls-ca-dir"`
+	ACME                 *acmeConfig       `yaml:"acme"`
+	TLSClientCAFiles     []string          `yaml:"tls-client-ca-files"`
+	UpstreamCertFile     string            `yaml:"upstream-cert-file"`
+	UpstreamKeyFile      string            `yaml:"upstream-key-file"`
+	UpstreamCAFiles      []string          `yaml:"upstream-ca-files"`
+	UpstreamInsecure     *bool             `yaml:"upstream-insecure-skip-verify"`
+	UpstreamServerName   string            `yaml:"upstream-server-name"`
+	UpstreamDialTimeout  time.Duration     `yaml:"upstream-dial-timeout"`
+	UpstreamTLSTimeout   time.Duration     `yaml:"upstream-tls-handshake-timeout"`
+	UpstreamRespTimeout  time.Duration     `yaml:"upstream-response-header-timeout"`
+	UpstreamKeepAlive    time.Duration     `yaml:"upstream-keep-alive"`
+	UpstreamNoKeepAlives *bool             `yaml:"upstream-disable-keep-alives"`
+	UpstreamMaxIdle      int               `yaml:"upstream-max-idle-conns"`
+	UpstreamMaxIdleHost  int               `yaml:"upstream-max-idle-conns-per-host"`
+	UpstreamIdleTimeout  time.Duration     `yaml:"upstream-idle-conn-timeout"`
+	UpstreamProxy        string            `yaml:"upstream-proxy"`
+	UpstreamNoProxy      []string          `yaml:"upstream-no-proxy"`
+	Routes               []routeConfig     `yaml:"routes"`
+	Overwrites           []overwriteConfig `yaml:"overwrites"`
+	Replaces             []replaceConfig   `yaml:"replaces"`
+	Mocks                []mockConfig      `yaml:"mocks"`
+}
+
+type routeConfig struct {
+	poolConfig   `yaml:",inline"`
+	PathPrefix   string            `yaml:"path-prefix"`
+	Host         string            `yaml:"host"`
+	Method       string            `yaml:"method"`
+	Target       string            `yaml:"target"`
+	StripPrefix  bool              `yaml:"strip-prefix"`
+	ReqHeaders   map[string]string `yaml:"req-headers"`
+	ResHeaders   map[string]string `yaml:"res-headers"`
+	CookieDomain string            `yaml:"cookie-domain"`
+	WebSocket    bool              `yaml:"websocket"`
+}
+
+// mockConfig is a mock route which responds without proxying
+type mockConfig struct {
+	Method   string            `yaml:"method"`
+	Path     string            `yaml:"path"`
+	Status   int               `yaml:"status"`
+	Headers  map[string]string `yaml:"headers"`
+	Body     string            `yaml:"body"`
+	BodyFile string            `yaml:"body-file"`
+	Template bool              `yaml:"template"`
+	Delay    time.Duration     `yaml:"delay"`
+}
+
+func (c mockConfig) toMock() *forward.Mock {
+	return &forward.Mock{
+		Method:   c.Method,
+		Path:     c.Path,
+		Status:   c.Status,
+		Header:   toHeader(c.Headers),
+		Body:     c.Body,
+		BodyFile: c.BodyFile,
+		Template: c.Template,
+		Delay:    c.Delay,
+	}
+}
+
+// overwriteConfig maps the requests to a local file or directory
+type overwriteConfig struct {
+	Type    string `yaml:"type"`
+	Pattern string `yaml:"pattern"`
+	Target  string `yaml:"target"`
+}
+
+func (c overwriteConfig) toRule() *forward.OverwriteRule {
+	return &forward.OverwriteRule{
+		Type:    c.Type,
+		Pattern: c.Pattern,
+		Target:  c.Target,
+	}
+}
+
+// parseOverwriteFlag parses the flag '[<type>:]<pattern>=<target>'
+func parseOverwriteFlag(value string) (*forward.OverwriteRule, error) {
+	rule := &forward.OverwriteRule{}
+	s := value
+
+	for _, t := range []string{forward.OverwriteGlob, forward.OverwriteRegex} {
+		if strings.HasPrefix(s, t+":") {
+			rule.Type = t
+			s = strings.TrimPrefix(s, t+":")
+			break
+		}
+	}
+
+	// the '=' can not be a part of path, so split at the last one
+	if i := strings.LastIndex(s, "="); i > 0 {
+		rule.Pattern = s[:i]
+		rule.Target = s[i+1:]
+	}
+
+	if rule.Pattern == "" || rule.Target == "" {
+		return nil, fmt.Errorf("invalid overwrite rule '%s', it should be '[<type>:]<pattern>=<target>'", value)
+	}
+
+	if err := rule.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid overwrite rule '%s': %s", value, err)
+	}
+
+	return rule, nil
+}
+
+// replaceConfig is a find/replace rule of response body
+type replaceConfig struct {
+	Type        string `yaml:"type"`
+	Find        string `yaml:"find"`
+	Replace     string `yaml:"replace"`
+	ContentType string `yaml:"content-type"`
+	Path        string `yaml:"path"`
+	Status      int    `yaml:"status"`
+}
+
+func (c replaceConfig) toRule() *forward.ReplaceRule {
+	return &forward.ReplaceRule{
+		Type:        c.Type,
+		Find:        c.Find,
+		Replace:     c.Replace,
+		ContentType: c.ContentType,
+		Path:        c.Path,
+		Status:      c.Status,
+	}
+}
+
+// parseReplaceFlag parses the flag '[<type>:]<find>=<replacement>', the '=' in find can be escaped as '\='
+func parseReplaceFlag(value string) (*forward.ReplaceRule, error) {
+	rule := &forward.ReplaceRule{}
+
+	for _, t := range []string{forward.ReplaceLiteral, forward.ReplaceRegex, forward.ReplaceJSONPath} {
+		if strings.HasPrefix(value, t+":") {
+			rule.Type = t
+			value = strings.TrimPrefix(value, t+":")
+			break
+		}
+	}
+
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) && value[i+1] == '=' {
+			i++
+			continue
+		}
+
+		if value[i] == '=' {
+			rule.Find = strings.ReplaceAll(value[:i], `\=`, "=")
+			rule.Replace = value[i+1:]
+			break
+		}
+	}
+
+	if rule.Find == "" {
+		return nil, fmt.Errorf("invalid replace rule '%s', it should be '[<type>:]<find>=<replacement>'", value)
+	}
+
+	if err := rule.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid replace rule '%s': %s", value, err)
+	}
+
+	return rule, nil
+}
+
+// poolConfig is the load balance settings of a target
+type poolConfig struct {
+	Targets       []string           `yaml:"targets"`
+	Balance       string             `yaml:"balance"`
+	BalanceCookie string             `yaml:"balance-cookie"`
+	HealthCheck   *healthCheckConfig `yaml:"health-check"`
+}
+
+// certConfig is a pair of certificate and key, the certificate is selected by the server name of client
+type certConfig struct {
+	CertFile string `yaml:"cert-file"`
+	KeyFile  string `yaml:"key-file"`
+}
+
+// acmeConfig provisions the certificates from an ACME server, eg. Let's Encrypt
+type acmeConfig struct {
+	Domains     []string `yaml:"domains"`
+	Email       string   `yaml:"email"`
+	CacheDir    string   `yaml:"cache-dir"`
+	Directory   string   `yaml:"directory"`
+	CAFile      string   `yaml:"ca-file"`
+	HTTPAddress *string  `yaml:"http-address"`
+}
+
+type healthCheckConfig struct {
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	HealthyThreshold   int           `yaml:"healthy-threshold"`
+	UnhealthyThreshold int           `yaml:"unhealthy-threshold"`
+}
+
+// lookupConfigFlag find the value of '--config' before the flags are parsed,
+// so that the values of config file can be used as the defaults of flags.
+func lookupConfigFlag(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+
+		name := strings.TrimLeft(arg, "-")
+
+		if name == arg {
+			continue
+		}
+
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+	}
+
+	return ""
+}
+
+func loadConfig(filePath string) (*config, error) {
+	f, err := os.Open(filePath)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer f.Close()
+
+	c := &config{}
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(c); err != nil {
+		return nil, errors.Errorf("invalid config file '%s': %s", filePath, err)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, errors.Errorf("invalid config file '%s': %s", filePath, err)
+	}
+
+	return c, nil
+}
+
+func (c *config) validate() error {
+	if c.Target != "" && !isHttpTarget(c.Target) {
+		return fmt.Errorf("key 'target' must be a http or https url, but got '%s'", c.Target)
+	}
+
+	if err := c.poolConfig.validate(""); err != nil {
+		return err
+	}
+
+	if c.HealthCheck != nil && c.Target == "" && len(c.Targets) == 0 {
+		return fmt.Errorf("key 'health-check' requires 'target' or 'targets'")
+	}
+
+	if c.Port != "" {
+		if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("key 'port' must be a number between 1 and 65535, but got '%s'", c.Port)
+		}
+	}
+
+	switch c.ListenProtocol {
+	case "", forward.ProtocolAuto, forward.ProtocolHTTP1, forward.ProtocolHTTP2, forward.ProtocolH2C:
+	default:
+		return fmt.Errorf("key 'listen-protocol' must be one of '%s', '%s', '%s' or '%s', but got '%s'", forward.ProtocolAuto, forward.ProtocolHTTP1, forward.ProtocolHTTP2, forward.ProtocolH2C, c.ListenProtocol)
+	}
+
+	switch c.UpstreamProtocol {
+	case "", forward.ProtocolAuto, forward.ProtocolHTTP1, forward.ProtocolHTTP2, forward.ProtocolH2C:
+	default:
+		return fmt.Errorf("key 'upstream-protocol' must be one of '%s', '%s', '%s' or '%s', but got '%s'", forward.ProtocolAuto, forward.ProtocolHTTP1, forward.ProtocolHTTP2, forward.ProtocolH2C, c.UpstreamProtocol)
+	}
+
+	for key := range c.ReqHeaders {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("key 'req-headers' contains an empty header name")
+		}
+	}
+
+	for key := range c.ResHeaders {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("key 'res-headers' contains an empty header name")
+		}
+	}
+
+	if c.Record != "" && c.Replay != "" {
+		return fmt.Errorf("key 'record' and 'replay' can not be specified together")
+	}
+
+	switch c.ReplayFallback {
+	case "", forward.ReplayFallbackNotFound, forward.ReplayFallbackPassthrough, forward.ReplayFallbackError:
+	default:
+		return fmt.Errorf("key 'replay-fallback' must be one of '%s', '%s' or '%s', but got '%s'", forward.ReplayFallbackNotFound, forward.ReplayFallbackPassthrough, forward.ReplayFallbackError, c.ReplayFallback)
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("key 'tls-cert-file' and 'tls-key-file' must be specified together")
+	}
+
+	for i, route := range c.Routes {
+		if route.Target == "" && len(route.Targets) == 0 {
+			return fmt.Errorf("key 'routes[%d].target' or 'routes[%d].targets' is required", i, i)
+		}
+
+		if route.Target != "" && !isHttpTarget(route.Target) {
+			return fmt.Errorf("key 'routes[%d].target' must be a http or https url, but got '%s'", i, route.Target)
+		}
+
+		if err := route.poolConfig.validate(fmt.Sprintf("routes[%d].", i)); err != nil {
+			return err
+		}
+
+		if route.PathPrefix != "" && !strings.HasPrefix(route.PathPrefix, "/") {
+			return fmt.Errorf("key 'routes[%d].path-prefix' must start with '/', but got '%s'", i, route.PathPrefix)
+		}
+
+		if route.StripPrefix && route.PathPrefix == "" {
+			return fmt.Errorf("key 'routes[%d].strip-prefix' requires 'routes[%d].path-prefix'", i, i)
+		}
+	}
+
+	for i, mock := range c.Mocks {
+		if !strings.HasPrefix(mock.Path, "/") {
+			return fmt.Errorf("key 'mocks[%d].path' must start with '/', but got '%s'", i, mock.Path)
+		}
+
+		if mock.Status != 0 && (mock.Status < 100 || mock.Status > 999) {
+			return fmt.Errorf("key 'mocks[%d].status' must be a valid status code, but got %d", i, mock.Status)
+		}
+
+		if mock.Body != "" && mock.BodyFile != "" {
+			return fmt.Errorf("key 'mocks[%d].body' and 'mocks[%d].body-file' can not be specified together", i, i)
+		}
+
+		if mock.Template && mock.Body != "" {
+			if _, err := template.New("").Parse(mock.Body); err != nil {
+				return fmt.Errorf("key 'mocks[%d].body' is an invalid template: %s", i, err)
+			}
+		}
+	}
+
+	for i, overwrite := range c.Overwrites {
+		if err := overwrite.toRule().Compile(); err != nil {
+			return fmt.Errorf("key 'overwrites[%d]' is invalid: %s", i, err)
+		}
+	}
+
+	for i, replace := range c.Replaces {
+		if err := replace.toRule().Compile(); err != nil {
+			return fmt.Errorf("key 'replaces[%d]' is invalid: %s", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *poolConfig) validate(keyPrefix string) error {
+	for i, target := range c.Targets {
+		if !isHttpTarget(target) {
+			return fmt.Errorf("key '%stargets[%d]' must be a http or https url, but got '%s'", keyPrefix, i, target)
+		}
+	}
+
+	switch c.Balance {
+	case "", forward.BalanceRoundRobin, forward.BalanceLeastConn, forward.BalanceCookieHash:
+	default:
+		return fmt.Errorf("key '%sbalance' must be one of '%s', '%s' or '%s', but got '%s'", keyPrefix, forward.BalanceRoundRobin, forward.BalanceLeastConn, forward.BalanceCookieHash, c.Balance)
+	}
+
+	if c.HealthCheck != nil {
+		if !strings.HasPrefix(c.HealthCheck.Path, "/") {
+			return fmt.Errorf("key '%shealth-check.path' must start with '/', but got '%s'", keyPrefix, c.HealthCheck.Path)
+		}
+
+		if c.HealthCheck.Interval < 0 {
+			return fmt.Errorf("key '%shealth-check.interval' must not be negative, eg. '10s'", keyPrefix)
+		}
+	}
+
+	return nil
+}
+
+// toPool creates the upstream pool with the target and targets, returns nil if there is no backend or only one backend without health check
+func (c *poolConfig) toPool(target string) *forward.UpstreamPool {
+	targets := c.Targets
+
+	if target != "" {
+		targets = append([]string{target}, targets...)
+	}
+
+	if len(targets) == 0 || (len(targets) == 1 && c.HealthCheck == nil) {
+		return nil
+	}
+
+	pool := &forward.UpstreamPool{
+		Strategy:   c.Balance,
+		HashCookie: c.BalanceCookie,
+	}
+
+	for _, t := range targets {
+		u, _ := url.Parse(t)
+		pool.Targets = append(pool.Targets, u)
+	}
+
+	if c.HealthCheck != nil {
+		pool.HealthCheck = &forward.HealthCheck{
+			Path:               c.HealthCheck.Path,
+			Interval:           c.HealthCheck.Interval,
+			Timeout:            c.HealthCheck.Timeout,
+			HealthyThreshold:   c.HealthCheck.HealthyThreshold,
+			UnhealthyThreshold: c.HealthCheck.UnhealthyThreshold,
+		}
+	}
+
+	return pool
+}
+
+func isHttpTarget(target string) bool {
+	u, err := url.Parse(target)
+
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func toHeader(m map[string]string) http.Header {
+	header := http.Header{}
+
+	for k, v := range m {
+		header.Set(k, v)
+	}
+
+	return header
+}
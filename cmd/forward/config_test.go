@@ -0,0 +1,240 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_loadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *config
+		wantErr string
+	}{
+		{
+			name:    "yaml",
+			content: "target: https://example.com\nport: 8080\nreq-headers:\n  foo: bar\n",
+			want: &config{
+				Target:     "https://example.com",
+				Port:       "8080",
+				ReqHeaders: map[string]string{"foo": "bar"},
+			},
+		},
+		{
+			name:    "json",
+			content: `{"target": "https://example.com", "port": 8080}`,
+			want: &config{
+				Target: "https://example.com",
+				Port:   "8080",
+			},
+		},
+		{
+			name:    "invalid replace",
+			content: "target: https://example.com\nreplaces:\n  - type: regex\n    find: '('\n",
+			wantErr: "key 'replaces[0]' is invalid",
+		},
+		{
+			name:    "invalid mock path",
+			content: "mocks:\n  - path: api/users\n    body: ok\n",
+			wantErr: "key 'mocks[0].path' must start with '/'",
+		},
+		{
+			name:    "unknown key",
+			content: "target: https://example.com\nfoo: bar\n",
+			wantErr: "field foo not found",
+		},
+		{
+			name:    "invalid target",
+			content: "target: ftp://example.com\n",
+			wantErr: "key 'target'",
+		},
+		{
+			name:    "invalid port",
+			content: "port: 70000\n",
+			wantErr: "key 'port'",
+		},
+		{
+			name:    "invalid balance strategy",
+			content: "targets:\n  - http://localhost:8080\nbalance: random\n",
+			wantErr: "key 'balance'",
+		},
+		{
+			name:    "route without target",
+			content: "routes:\n  - path-prefix: /api\n    health-check:\n      path: /healthz\n      interval: 5s\n",
+			wantErr: "key 'routes[0].target' or 'routes[0].targets'",
+		},
+		{
+			name:    "invalid health check interval",
+			content: "routes:\n  - targets: [http://localhost:3000]\n    health-check:\n      path: /healthz\n      interval: -5s\n",
+			wantErr: "key 'routes[0].health-check.interval'",
+		},
+		{
+			name:    "default health check interval",
+			content: "targets: [http://localhost:3000]\nhealth-check:\n  path: /healthz\n",
+			want:    &config{},
+		},
+		{
+			name:    "health check without targets",
+			content: "health-check:\n  path: /healthz\n",
+			wantErr: "key 'health-check' requires 'target' or 'targets'",
+		},
+		{
+			name:    "invalid listen protocol",
+			content: "listen-protocol: h3\n",
+			wantErr: "key 'listen-protocol'",
+		},
+		{
+			name:    "tls key without cert",
+			content: "tls-key-file: server.key\n",
+			wantErr: "key 'tls-cert-file' and 'tls-key-file'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "forward.yaml")
+
+			if err := os.WriteFile(filePath, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := loadConfig(filePath)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("loadConfig() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+
+			if got.Target != tt.want.Target || got.Port != tt.want.Port || len(got.ReqHeaders) != len(tt.want.ReqHeaders) {
+				t.Errorf("loadConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_lookupConfigFlag(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "equal sign", args: []string{"--config=forward.yaml", "http://example.com"}, want: "forward.yaml"},
+		{name: "separated", args: []string{"-config", "forward.json"}, want: "forward.json"},
+		{name: "none", args: []string{"--port=80", "http://example.com"}, want: ""},
+		{name: "after terminator", args: []string{"--", "--config=forward.yaml"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lookupConfigFlag(tt.args); got != tt.want {
+				t.Errorf("lookupConfigFlag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_listFlag(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "config only", args: []string{}, want: []string{"foo=config"}},
+		{name: "replaced by flags", args: []string{"--req-header=foo=flag", "--req-header=bar=flag"}, want: []string{"foo=flag", "bar=flag"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := arrayFlags{"foo=config"}
+
+			fs := flag.NewFlagSet("forward", flag.ContinueOnError)
+			fs.Var(&listFlag{values: &values}, "req-header", "")
+
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual([]string(values), tt.want) {
+				t.Errorf("values = %v, want %v", values, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseReplaceFlag(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		wantType    string
+		wantFind    string
+		wantReplace string
+		wantErr     bool
+	}{
+		{name: "literal", value: "foo=bar", wantFind: "foo", wantReplace: "bar"},
+		{name: "url", value: "http://a.com=http://b.com?x=1", wantFind: "http://a.com", wantReplace: "http://b.com?x=1"},
+		{name: "regex", value: `regex:v(\d+)=v$1-dev`, wantType: "regex", wantFind: `v(\d+)`, wantReplace: "v$1-dev"},
+		{name: "escaped", value: `literal:a\=b=c`, wantType: "literal", wantFind: "a=b", wantReplace: "c"},
+		{name: "jsonpath", value: "jsonpath:$.features.banner=false", wantType: "jsonpath", wantFind: "$.features.banner", wantReplace: "false"},
+		{name: "empty find", value: "=bar", wantErr: true},
+		{name: "invalid regex", value: "regex:(=bar", wantErr: true},
+		{name: "invalid jsonpath", value: "jsonpath:features=bar", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseReplaceFlag(tt.value)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReplaceFlag() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got.Type != tt.wantType || got.Find != tt.wantFind || got.Replace != tt.wantReplace {
+				t.Errorf("parseReplaceFlag() = %+v", got)
+			}
+		})
+	}
+}
+
+func Test_parseOverwriteFlag(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		wantType    string
+		wantPattern string
+		wantTarget  string
+		wantErr     bool
+	}{
+		{name: "glob", value: "/static/js/app.*.js=./dist/app.js", wantPattern: "/static/js/app.*.js", wantTarget: "./dist/app.js"},
+		{name: "regex", value: `regex:/assets/(\w+)\.js=./dist/$1.js`, wantType: "regex", wantPattern: `/assets/(\w+)\.js`, wantTarget: "./dist/$1.js"},
+		{name: "no target", value: "/static/app.js", wantErr: true},
+		{name: "invalid regex", value: "regex:/assets/(=./dist", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOverwriteFlag(tt.value)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOverwriteFlag() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got.Type != tt.wantType || got.Pattern != tt.wantPattern || got.Target != tt.wantTarget {
+				t.Errorf("parseOverwriteFlag() = %+v", got)
+			}
+		})
+	}
+}
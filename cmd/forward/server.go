@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	forward "github.com/axetroy/forward-cli"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newServer creates the server of proxy with the protocol.
+// HTTP/2 is negotiated over TLS by default, 'h2c' serves HTTP/2 over cleartext TCP as well, 'http1' disables HTTP/2.
+func newServer(addr string, handler http.Handler, protocol string) (*http.Server, error) {
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: &tls.Config{},
+	}
+
+	switch protocol {
+	case "", forward.ProtocolAuto, forward.ProtocolHTTP2:
+		if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	case forward.ProtocolH2C:
+		h2s := &http2.Server{}
+
+		if err := http2.ConfigureServer(server, h2s); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		// the requests with prior knowledge or 'Upgrade: h2c' are served with HTTP/2, others with HTTP/1.1
+		server.Handler = h2c.NewHandler(handler, h2s)
+	case forward.ProtocolHTTP1:
+		// a non-nil empty map disables HTTP/2
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	default:
+		return nil, errors.Errorf("invalid protocol '%s', it must be '%s', '%s', '%s' or '%s'", protocol, forward.ProtocolAuto, forward.ProtocolHTTP1, forward.ProtocolHTTP2, forward.ProtocolH2C)
+	}
+
+	return server, nil
+}
+
+// defaultCADir returns the folder of local CA in the config directory of user
+func defaultCADir() (string, error) {
+	dir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return filepath.Join(dir, "forward-cli", "ca"), nil
+}
+
+// trustInstructions returns the commands to trust the root certificate on the current system
+func trustInstructions(certFile string) string {
+	var command string
+
+	switch runtime.GOOS {
+	case "darwin":
+		command = fmt.Sprintf("sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain '%s'", certFile)
+	case "windows":
+		command = fmt.Sprintf("certutil -addstore -f ROOT \"%s\"", certFile)
+	default:
+		command = fmt.Sprintf("sudo cp '%s' /usr/local/share/ca-certificates/forward-cli.crt && sudo update-ca-certificates", certFile)
+	}
+
+	return fmt.Sprintf(`The local CA is created at '%s', trust it to get rid of the certificate warnings:
+
+  %s
+
+Firefox uses its own certificate store, import the file in 'Settings > Privacy & Security > Certificates'.`, certFile, command)
+}
+
+// loadCertPool loads the PEM encoded certificates of CA from files
+func loadCertPool(files []string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificate found in '%s'", f)
+		}
+	}
+
+	return pool, nil
+}
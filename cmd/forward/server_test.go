@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	forward "github.com/axetroy/forward-cli"
+	"golang.org/x/net/http2"
+)
+
+func Test_newServer(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})
+
+	if _, err := newServer(":0", handler, "http3"); err == nil {
+		t.Errorf("newServer() should return error for the invalid protocol")
+	}
+
+	server, err := newServer(":0", handler, forward.ProtocolH2C)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+
+	for _, c := range []struct {
+		client *http.Client
+		want   string
+	}{{client: http.DefaultClient, want: "HTTP/1.1"}, {client: client, want: "HTTP/2.0"}} {
+		res, err := c.client.Get("http://" + listener.Addr().String())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if string(body) != c.want {
+			t.Errorf("protocol = %s, want %s", body, c.want)
+		}
+	}
+}
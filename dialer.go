@@ -0,0 +1,181 @@
+package forward
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// proxyFunc returns the function which selects the outbound proxy of the upstream URL, nil means connecting directly.
+// the env HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used if they are not specified.
+// the requests to localhost and the loopback addresses never use a proxy.
+func (o *TransportOptions) proxyFunc() func(*url.URL) (*url.URL, error) {
+	config := httpproxy.FromEnvironment()
+
+	if o != nil && o.Proxy != nil {
+		config.HTTPProxy = o.Proxy.String()
+		config.HTTPSProxy = o.Proxy.String()
+	}
+
+	if o != nil && len(o.NoProxy) > 0 {
+		config.NoProxy = strings.Join(o.NoProxy, ",")
+	}
+
+	return config.ProxyFunc()
+}
+
+// dialContext returns the function which connects to upstream, through the outbound proxy with a tunnel if any.
+// the scheme of upstream selects the proxy, the TLS of upstream is not handled.
+func (o *TransportOptions) dialContext(scheme string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxyFunc := o.proxyFunc()
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		proxyURL, err := proxyFunc(&url.URL{Scheme: scheme, Host: addr})
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if proxyURL == nil {
+			return o.dialer().DialContext(ctx, network, addr)
+		}
+
+		switch proxyURL.Scheme {
+		case "socks5", "socks5h":
+			return o.dialSOCKS5(ctx, proxyURL, network, addr)
+		case "http", "https":
+			return o.dialConnect(ctx, proxyURL, addr)
+		default:
+			return nil, errors.Errorf("unsupported proxy scheme '%s'", proxyURL.Scheme)
+		}
+	}
+}
+
+func (o *TransportOptions) dialSOCKS5(ctx context.Context, proxyURL *url.URL, network, addr string) (net.Conn, error) {
+	var auth *proxy.Auth
+
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", canonicalProxyAddr(proxyURL), auth, o.dialer())
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+
+	return conn, errors.WithStack(err)
+}
+
+// dialConnect opens a tunnel to upstream with the CONNECT method of HTTP proxy
+func (o *TransportOptions) dialConnect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := o.dialer().DialContext(ctx, "tcp", canonicalProxyAddr(proxyURL))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+
+		if err := o.handshake(ctx, tlsConn); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	// the deadline of context interrupts the tunnel establishing
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	br := bufio.NewReader(conn)
+
+	res, err := http.ReadResponse(br, req)
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		_ = conn.Close()
+
+		err := errors.Errorf("the proxy '%s' refused to connect '%s': %s", proxyURL.Host, addr, res.Status)
+
+		switch res.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			// the proxy can not reach the upstream, it is a dial error so that the backend is ejected passively
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: err}
+		default:
+			return nil, err
+		}
+	}
+
+	// the proxy does not send anything before the tunnel is established
+	if br.Buffered() > 0 {
+		_ = conn.Close()
+		return nil, errors.Errorf("unexpected data from the proxy '%s'", proxyURL.Host)
+	}
+
+	return conn, nil
+}
+
+// canonicalProxyAddr returns the address of proxy with the default port of scheme
+func canonicalProxyAddr(proxyURL *url.URL) string {
+	if proxyURL.Port() != "" {
+		return proxyURL.Host
+	}
+
+	port := "1080"
+
+	switch proxyURL.Scheme {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	}
+
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
@@ -7,3 +7,18 @@ require github.com/andybalholm/brotli v1.0.4
 require github.com/pkg/errors v0.9.1
 
 require mvdan.cc/xurls/v2 v2.3.0
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require golang.org/x/net v0.7.0
+
+require github.com/fsnotify/fsnotify v1.5.4
+
+require golang.org/x/crypto v0.6.0
+
+require golang.org/x/text v0.7.0 // indirect
+
+require (
+	go.starlark.net v0.0.0-20220714194419-4cadf0a12139
+	golang.org/x/sys v0.5.0 // indirect
+)
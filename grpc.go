@@ -0,0 +1,204 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	grpcContentType        = "application/grpc"
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+	// the flag of the frame which carries the trailers in gRPC-Web body
+	grpcWebTrailerFlag = 0x80
+)
+
+// grpcWebRequest is the state of a gRPC-Web request which is translated to gRPC
+type grpcWebRequest struct {
+	text   bool   // the body is base64 encoded, eg. 'application/grpc-web-text'
+	origin string // the Origin header of browser
+}
+
+// isGRPC reports whether the content type is gRPC, eg. 'application/grpc' or 'application/grpc+proto'
+func isGRPC(contentType string) bool {
+	contentType = mediaType(contentType)
+
+	return contentType == grpcContentType || strings.HasPrefix(contentType, grpcContentType+"+")
+}
+
+func isGRPCWebContentType(contentType string) bool {
+	_, _, ok := parseGRPCWeb(contentType)
+
+	return ok
+}
+
+// parseGRPCWeb returns the gRPC content type of the gRPC-Web content type, and whether the body is base64 encoded
+func parseGRPCWeb(contentType string) (string, bool, bool) {
+	contentType = mediaType(contentType)
+
+	for _, prefix := range []string{grpcWebTextContentType, grpcWebContentType} {
+		if !strings.HasPrefix(contentType, prefix) {
+			continue
+		}
+
+		// the format of message, eg. '+proto'
+		format := contentType[len(prefix):]
+
+		if format != "" && !strings.HasPrefix(format, "+") {
+			return "", false, false
+		}
+
+		return grpcContentType + format, prefix == grpcWebTextContentType, true
+	}
+
+	return "", false, false
+}
+
+// isGRPCWebPreflight reports whether the request is the CORS preflight of gRPC-Web, the upstream of gRPC can not handle it
+func isGRPCWebPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Access-Control-Request-Method") != "" &&
+		strings.Contains(strings.ToLower(r.Header.Get("Access-Control-Request-Headers")), "x-grpc-web")
+}
+
+func serveGRPCWebPreflight(w http.ResponseWriter, r *http.Request) {
+	setGRPCWebCors(w.Header(), r.Header.Get("Origin"))
+
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setGRPCWebCors(header http.Header, origin string) {
+	if header.Get("Access-Control-Allow-Origin") == "" {
+		if origin == "" {
+			origin = "*"
+		}
+
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	header.Set("Access-Control-Expose-Headers", "grpc-status, grpc-message")
+}
+
+// translateGRPCWebRequest converts the gRPC-Web request of browser to gRPC, returns nil if it is not a gRPC-Web request
+func translateGRPCWebRequest(r *http.Request) *grpcWebRequest {
+	contentType, text, ok := parseGRPCWeb(r.Header.Get("Content-Type"))
+
+	if !ok {
+		return nil
+	}
+
+	g := &grpcWebRequest{
+		text:   text,
+		origin: r.Header.Get("Origin"),
+	}
+
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("Te", "trailers")
+	r.Header.Del("X-Grpc-Web")
+
+	if text {
+		r.Body = &readCloser{Reader: base64.NewDecoder(base64.StdEncoding, r.Body), Closer: r.Body}
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+	}
+
+	return g
+}
+
+// translateGRPCResponse converts the gRPC response to gRPC-Web, the trailers are sent as the last frame of body
+func (g *grpcWebRequest) translateGRPCResponse(res *http.Response) {
+	contentType := mediaType(res.Header.Get("Content-Type"))
+
+	// the response of error may be not gRPC, eg. 404
+	if !isGRPC(contentType) {
+		return
+	}
+
+	// keep the format of message, eg. '+proto'
+	if g.text {
+		contentType = grpcWebTextContentType + contentType[len(grpcContentType):]
+	} else {
+		contentType = grpcWebContentType + contentType[len(grpcContentType):]
+	}
+
+	origin := res.Body
+
+	// the trailers are not announced to client, the transport sets them to a new map after the body is read
+	res.Trailer = nil
+	res.Header.Del("Trailer")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Header.Set("Content-Type", contentType)
+
+	setGRPCWebCors(res.Header, g.origin)
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		var (
+			w       io.Writer = pw
+			encoder io.WriteCloser
+		)
+
+		if g.text {
+			encoder = base64.NewEncoder(base64.StdEncoding, pw)
+			w = encoder
+		}
+
+		_, err := io.Copy(w, origin)
+
+		// the trailers are sent in body, so they are removed before the proxy copies them
+		trailer := res.Trailer
+		res.Trailer = nil
+
+		if err == nil && len(trailer) > 0 {
+			_, err = w.Write(grpcWebTrailerFrame(trailer))
+		}
+
+		if encoder != nil {
+			if closeErr := encoder.Close(); err == nil {
+				err = closeErr
+			}
+		}
+
+		_ = origin.Close()
+		_ = pw.CloseWithError(err)
+	}()
+
+	res.Body = &pipeBody{PipeReader: pr, origin: origin}
+}
+
+// grpcWebTrailerFrame encodes the trailers as a frame of gRPC-Web body, the keys are lower case
+func grpcWebTrailerFrame(trailer http.Header) []byte {
+	keys := make([]string, 0, len(trailer))
+
+	for k := range trailer {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	buf := &bytes.Buffer{}
+
+	for _, k := range keys {
+		for _, v := range trailer[k] {
+			buf.WriteString(fmt.Sprintf("%s: %s\r\n", strings.ToLower(k), v))
+		}
+	}
+
+	frame := make([]byte, 5, 5+buf.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(buf.Len()))
+
+	return append(frame, buf.Bytes()...)
+}
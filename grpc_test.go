@@ -0,0 +1,160 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func Test_parseGRPCWeb(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        string
+		wantText    bool
+		wantOk      bool
+	}{
+		{contentType: "application/grpc-web", want: "application/grpc", wantOk: true},
+		{contentType: "application/grpc-web+proto", want: "application/grpc+proto", wantOk: true},
+		{contentType: "application/grpc-web-text+proto; charset=utf-8", want: "application/grpc+proto", wantText: true, wantOk: true},
+		{contentType: "application/grpc-webx", wantOk: false},
+		{contentType: "application/grpc", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			got, text, ok := parseGRPCWeb(tt.contentType)
+
+			if got != tt.want || text != tt.wantText || ok != tt.wantOk {
+				t.Errorf("parseGRPCWeb() = %v, %v, %v, want %v, %v, %v", got, text, ok, tt.want, tt.wantText, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestProxyServer_grpc(t *testing.T) {
+	message := []byte{0, 0, 0, 0, 2, 'h', 'i'}
+
+	// echo the message, it is served with HTTP/2 only
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc+proto" {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		_, _ = w.Write(body)
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "ok")
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+
+	server := NewProxyServer(&ProxyServerOptions{
+		Target:  target,
+		GRPCWeb: true,
+	})
+	defer server.Close()
+
+	proxy := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(server.Handler()), &http2.Server{}))
+	defer proxy.Close()
+
+	t.Run("grpc", func(t *testing.T) {
+		client := &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}}
+
+		req, _ := http.NewRequest(http.MethodPost, proxy.URL, bytes.NewReader(message))
+		req.Header.Set("Content-Type", "application/grpc+proto")
+
+		res, err := client.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if !bytes.Equal(body, message) {
+			t.Errorf("body = %v, want %v", body, message)
+		}
+
+		if res.Trailer.Get("Grpc-Status") != "0" || res.Trailer.Get("Grpc-Message") != "ok" {
+			t.Errorf("trailer = %v", res.Trailer)
+		}
+	})
+
+	t.Run("grpc-web-text", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, proxy.URL, strings.NewReader(base64.StdEncoding.EncodeToString(message)))
+		req.Header.Set("Content-Type", "application/grpc-web-text+proto")
+		req.Header.Set("X-Grpc-Web", "1")
+		req.Header.Set("Origin", "http://localhost:3000")
+
+		res, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		body, err := base64.StdEncoding.DecodeString(string(b))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		trailer := "grpc-message: ok\r\ngrpc-status: 0\r\n"
+		want := append(append([]byte{}, message...), 0x80, 0, 0, 0, byte(len(trailer)))
+		want = append(want, trailer...)
+
+		if !bytes.Equal(body, want) {
+			t.Errorf("body = %q, want %q", body, want)
+		}
+
+		if ct := res.Header.Get("Content-Type"); ct != "application/grpc-web-text+proto" {
+			t.Errorf("Content-Type = %s", ct)
+		}
+
+		if origin := res.Header.Get("Access-Control-Allow-Origin"); origin != "http://localhost:3000" {
+			t.Errorf("Access-Control-Allow-Origin = %s", origin)
+		}
+	})
+
+	t.Run("preflight", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodOptions, proxy.URL, nil)
+		req.Header.Set("Origin", "http://localhost:3000")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+
+		res, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusNoContent || res.Header.Get("Access-Control-Allow-Headers") != "content-type,x-grpc-web" {
+			t.Errorf("preflight = %d %v", res.StatusCode, res.Header)
+		}
+	})
+}
@@ -0,0 +1,326 @@
+package forward
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const defaultHARMaxBodySize = 1024 * 1024
+
+// HAR 1.2 document, see http://www.softwareishard.com/blog/har-12-spec/
+// the fields start with '_' are custom fields which are allowed by the spec.
+type harEntry struct {
+	StartedDateTime  string       `json:"startedDateTime"`
+	Time             float64      `json:"time"`
+	Request          harRequest   `json:"request"`
+	Response         harResponse  `json:"response"`
+	Cache            struct{}     `json:"cache"`
+	Timings          harTimings   `json:"timings"`
+	Comment          string       `json:"comment,omitempty"`
+	RewrittenRequest *harRequest  `json:"_rewrittenRequest,omitempty"` // the request sent to upstream
+	UpstreamResponse *harResponse `json:"_upstreamResponse,omitempty"` // the response received from upstream before rewriting
+	Error            string       `json:"_error,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+const harTrailer = "]}}\n"
+
+// HARRecorder writes the traffic passed through the proxy server to a HAR file.
+// The file is a valid HAR document after each exchange is written, so it is not lost if the process crashes.
+type HARRecorder struct {
+	file        *os.File
+	offset      int64 // the offset to write the next entry
+	count       int
+	maxBodySize int
+	mu          sync.Mutex
+}
+
+// NewHARRecorder creates the HAR file. the body larger than maxBodySize will be truncated.
+func NewHARRecorder(filePath string, maxBodySize int) (*HARRecorder, error) {
+	f, err := os.Create(filePath)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	version := "dev"
+
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		version = info.Main.Version
+	}
+
+	creator, _ := json.Marshal(map[string]string{"name": "forward-cli", "version": version})
+	header := `{"log":{"version":"1.2","creator":` + string(creator) + `,"pages":[],"entries":[`
+
+	if _, err := f.WriteString(header + harTrailer); err != nil {
+		_ = f.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	if maxBodySize <= 0 {
+		maxBodySize = defaultHARMaxBodySize
+	}
+
+	return &HARRecorder{
+		file:        f,
+		offset:      int64(len(header)),
+		maxBodySize: maxBodySize,
+	}, nil
+}
+
+// Close closes the HAR file
+func (h *HARRecorder) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.file.Close()
+}
+
+func (h *HARRecorder) observe(e *exchange) {
+	b, err := json.Marshal(newHAREntry(e))
+
+	if err != nil {
+		log.Printf("failed to encode HAR entry: %+v\n", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count > 0 {
+		b = append([]byte(","), b...)
+	}
+
+	// overwrite the trailer with the new entry, and append the trailer again
+	if _, err := h.file.WriteAt(append(b, harTrailer...), h.offset); err != nil {
+		log.Printf("failed to write HAR file: %+v\n", errors.WithStack(err))
+		return
+	}
+
+	h.offset += int64(len(b))
+	h.count++
+}
+
+func newHAREntry(e *exchange) *harEntry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entry := &harEntry{
+		StartedDateTime: e.startedAt.Format(time.RFC3339Nano),
+		Time:            milliseconds(e.finishedAt.Sub(e.startedAt)),
+		Request:         newHARRequest(&e.request),
+		Response:        newHARResponse(&e.response),
+		Timings: harTimings{
+			Blocked: -1,
+			DNS:     -1,
+			Connect: -1,
+			SSL:     -1,
+		},
+	}
+
+	if e.upstreamRequest != nil {
+		r := newHARRequest(e.upstreamRequest)
+		entry.RewrittenRequest = &r
+	}
+
+	if e.upstreamResponse != nil {
+		r := newHARResponse(e.upstreamResponse)
+		entry.UpstreamResponse = &r
+	}
+
+	if e.err != nil {
+		entry.Error = e.err.Error()
+	}
+
+	if !e.upstreamSentAt.IsZero() && !e.upstreamRespondedAt.IsZero() {
+		wroteAt := e.upstreamWroteAt
+
+		// the response may be received before the request body is written completely
+		if wroteAt.IsZero() || wroteAt.After(e.upstreamRespondedAt) {
+			wroteAt = e.upstreamRespondedAt
+		}
+
+		// the time spent by the proxy server before sending, eg. the middlewares
+		entry.Timings.Blocked = milliseconds(e.upstreamSentAt.Sub(e.startedAt))
+		entry.Timings.Send = milliseconds(wroteAt.Sub(e.upstreamSentAt))
+		entry.Timings.Wait = milliseconds(e.upstreamRespondedAt.Sub(wroteAt))
+		entry.Timings.Receive = milliseconds(e.finishedAt.Sub(e.upstreamRespondedAt))
+	} else {
+		// the response is not from upstream
+		entry.Timings.Wait = entry.Time
+	}
+
+	return entry
+}
+
+func newHARRequest(m *capturedMessage) harRequest {
+	r := harRequest{
+		Method:      m.method,
+		URL:         m.url,
+		HTTPVersion: m.proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(m.header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+	}
+
+	if u, err := url.Parse(m.url); err == nil {
+		for k, values := range u.Query() {
+			for _, v := range values {
+				r.QueryString = append(r.QueryString, harNameValue{Name: k, Value: v})
+			}
+		}
+	}
+
+	req := http.Request{Header: m.header}
+
+	for _, c := range req.Cookies() {
+		r.Cookies = append(r.Cookies, harNameValue{Name: c.Name, Value: c.Value})
+	}
+
+	if m.body != nil {
+		r.BodySize = m.body.size
+
+		if m.body.size > 0 {
+			text, _ := harText(m.body.decoded(m.header.Get("Content-Encoding")))
+
+			r.PostData = &harPostData{
+				MimeType: m.header.Get("Content-Type"),
+				Text:     text,
+			}
+		}
+	}
+
+	return r
+}
+
+func newHARResponse(m *capturedMessage) harResponse {
+	r := harResponse{
+		Status:      m.status,
+		StatusText:  http.StatusText(m.status),
+		HTTPVersion: m.proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(m.header),
+		RedirectURL: m.header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    -1,
+		Content: harContent{
+			MimeType: m.header.Get("Content-Type"),
+		},
+	}
+
+	res := http.Response{Header: m.header}
+
+	for _, c := range res.Cookies() {
+		r.Cookies = append(r.Cookies, harNameValue{Name: c.Name, Value: c.Value})
+	}
+
+	if m.body != nil {
+		body := m.body.decoded(m.header.Get("Content-Encoding"))
+
+		r.BodySize = m.body.size
+		r.Content.Size = len(body)
+		r.Content.Text, r.Content.Encoding = harText(body)
+
+		if m.body.truncated {
+			r.Content.Comment = "the body is truncated"
+		}
+	}
+
+	if r.Content.MimeType == "" {
+		r.Content.MimeType = "application/octet-stream"
+	}
+
+	return r
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	headers := []harNameValue{}
+
+	for k, values := range header {
+		for _, v := range values {
+			headers = append(headers, harNameValue{Name: k, Value: v})
+		}
+	}
+
+	sort.SliceStable(headers, func(i, j int) bool {
+		return headers[i].Name < headers[j].Name
+	})
+
+	return headers
+}
+
+// harText returns the text of body, the binary body is encoded with base64
+func harText(body []byte) (text string, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
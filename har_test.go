@@ -0,0 +1,159 @@
+package forward
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHARRecorder(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "traffic.har")
+
+	recorder, err := NewHARRecorder(filePath, 4)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer recorder.Close()
+
+	for i := 0; i < 2; i++ {
+		body := newBodyCapture(4)
+		_, _ = body.Write([]byte("hello world"))
+
+		recorder.observe(&exchange{
+			startedAt:  time.Now(),
+			finishedAt: time.Now(),
+			request: capturedMessage{
+				method: http.MethodGet,
+				url:    "http://localhost/?foo=bar",
+				proto:  "HTTP/1.1",
+				header: http.Header{"Cookie": []string{"a=b"}},
+			},
+			response: capturedMessage{
+				proto:  "HTTP/1.1",
+				status: http.StatusOK,
+				header: http.Header{"Content-Type": []string{"text/plain"}},
+				body:   body,
+			},
+		})
+
+		// the file must be a valid HAR document after each entry is written
+		b, err := os.ReadFile(filePath)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var har struct {
+			Log struct {
+				Version string     `json:"version"`
+				Entries []harEntry `json:"entries"`
+			} `json:"log"`
+		}
+
+		if err := json.Unmarshal(b, &har); err != nil {
+			t.Fatalf("invalid HAR file: %v\n%s", err, b)
+		}
+
+		if har.Log.Version != "1.2" || len(har.Log.Entries) != i+1 {
+			t.Fatalf("got version %s with %d entries", har.Log.Version, len(har.Log.Entries))
+		}
+
+		entry := har.Log.Entries[i]
+
+		if entry.Response.Content.Text != "hell" || entry.Response.BodySize != 11 {
+			t.Errorf("got response content %+v", entry.Response.Content)
+		}
+
+		if len(entry.Request.QueryString) != 1 || len(entry.Request.Cookies) != 1 {
+			t.Errorf("got request %+v", entry.Request)
+		}
+	}
+}
+
+func TestProxyServer_har(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+	filePath := filepath.Join(t.TempDir(), "traffic.har")
+
+	recorder, err := NewHARRecorder(filePath, 1024)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer recorder.Close()
+
+	server := NewProxyServer(&ProxyServerOptions{
+		Target: target,
+		HAR:    recorder,
+		RequestMiddlewares: []RequestMiddleware{
+			RequestMiddlewareFunc(func(req *http.Request) (*http.Response, error) {
+				_, _ = ioutil.ReadAll(req.Body)
+
+				req.Body = ioutil.NopCloser(strings.NewReader("rewritten"))
+				req.ContentLength = int64(len("rewritten"))
+
+				return nil, nil
+			}),
+		},
+	})
+	defer server.Close()
+
+	proxy := httptest.NewServer(http.HandlerFunc(server.Handler()))
+	defer proxy.Close()
+
+	res, err := http.Post(proxy.URL, "text/plain", strings.NewReader("original"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	b, err := os.ReadFile(filePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var har struct {
+		Log struct {
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+
+	if err := json.Unmarshal(b, &har); err != nil || len(har.Log.Entries) != 1 {
+		t.Fatalf("invalid HAR file: %v\n%s", err, b)
+	}
+
+	entry := har.Log.Entries[0]
+
+	if entry.Request.PostData == nil || entry.Request.PostData.Text != "original" {
+		t.Errorf("request post data = %+v, want 'original'", entry.Request.PostData)
+	}
+
+	if entry.RewrittenRequest == nil || entry.RewrittenRequest.PostData == nil || entry.RewrittenRequest.PostData.Text != "rewritten" {
+		t.Errorf("rewritten request = %+v, want the post data 'rewritten'", entry.RewrittenRequest)
+	}
+
+	// the upstream responds slowly after the request is written
+	if entry.Timings.Wait < 100 || entry.Timings.Send >= entry.Timings.Wait {
+		t.Errorf("timings = %+v, want the wait longer than 100ms and the send", entry.Timings)
+	}
+}
@@ -0,0 +1,277 @@
+package forward
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	regCSSURL         = regexp.MustCompile(`(url\(\s*['"]?)([^'")]+)(['"]?\s*\))`)
+	regCSSImport      = regexp.MustCompile(`(@import\s+['"])([^'"]+)(['"])`)
+	regMetaRefreshURL = regexp.MustCompile(`(?i)^(\s*\d*\s*[;,]\s*url\s*=\s*['"]?)([^'"]+)(['"]?\s*)$`)
+	regAbsoluteURL    = regexp.MustCompile(`^(?i)(https?:|wss?:)?//`)
+
+	// the attributes which value is a url
+	urlAttributes = map[string]struct{}{
+		"href":       {},
+		"src":        {},
+		"action":     {},
+		"formaction": {},
+		"poster":     {},
+		"data":       {},
+		"cite":       {},
+		"background": {},
+		"manifest":   {},
+		"icon":       {},
+		"longdesc":   {},
+	}
+
+	// the attributes which value is a list of image candidates
+	srcsetAttributes = map[string]struct{}{
+		"srcset":      {},
+		"imagesrcset": {},
+	}
+)
+
+// urlRewriter rewrites the urls in HTML to the proxy server
+type urlRewriter struct {
+	originHost           string
+	proxyHost            string
+	useSSL               bool
+	proxyExternal        bool
+	proxyExternalIgnores []string
+	// the url of page which is used to resolve the relative urls, it is updated by <base href>
+	base *url.URL
+	// whether the page is proxied with 'forward_url', the relative urls must be resolved with the page url
+	isProxyUrl bool
+	// inject the live reload script before '</body>'
+	injectLiveReload bool
+}
+
+func (u *urlRewriter) rewrite(value string) string {
+	s := strings.TrimSpace(value)
+
+	if s == "" || strings.HasPrefix(s, "#") {
+		return value
+	}
+
+	if regAbsoluteURL.MatchString(s) {
+		return replaceHost(s, u.originHost, u.proxyHost, u.useSSL, u.proxyExternal, u.proxyExternalIgnores)
+	}
+
+	// the relative url of a page proxied with 'forward_url' can not be resolved by browser
+	if u.isProxyUrl && u.base != nil {
+		ref, err := url.Parse(s)
+
+		// skip the url with other schemes, eg. 'data:', 'javascript:', 'mailto:'
+		if err != nil || ref.Scheme != "" {
+			return value
+		}
+
+		scheme := "http"
+
+		if u.useSSL {
+			scheme = "https"
+		}
+
+		return fmt.Sprintf("%s://%s/?forward_url=%s", scheme, u.proxyHost, url.QueryEscape(u.base.ResolveReference(ref).String()))
+	}
+
+	return value
+}
+
+// rewriteSrcset rewrites the urls of image candidates, eg. 'a.png 1x, b.png 2x'
+func (u *urlRewriter) rewriteSrcset(value string) string {
+	candidates := strings.Split(value, ",")
+
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		fields[0] = u.rewrite(fields[0])
+		candidates[i] = strings.Join(fields, " ")
+	}
+
+	return strings.Join(candidates, ", ")
+}
+
+// rewriteCSS rewrites the urls in 'url()' and '@import' of CSS
+func (u *urlRewriter) rewriteCSS(css string) string {
+	replace := func(reg *regexp.Regexp, s string) string {
+		return reg.ReplaceAllStringFunc(s, func(match string) string {
+			groups := reg.FindStringSubmatch(match)
+
+			return groups[1] + u.rewrite(groups[2]) + groups[3]
+		})
+	}
+
+	return replace(regCSSImport, replace(regCSSURL, css))
+}
+
+func (u *urlRewriter) rewriteMetaRefresh(content string) string {
+	groups := regMetaRefreshURL.FindStringSubmatch(content)
+
+	if groups == nil {
+		return content
+	}
+
+	return groups[1] + u.rewrite(groups[2]) + groups[3]
+}
+
+// rewriteHTML rewrites the url-bearing attributes of HTML precisely with a tokenizer.
+// it removes the 'integrity' attributes and the CSP meta elements, and leaves the text content alone.
+// the content of <style> is rewritten the same as a CSS file, and the hosts in the content of <script> are replaced as plain text without parsing.
+func rewriteHTML(w io.Writer, r io.Reader, u *urlRewriter) error {
+	z := html.NewTokenizer(r)
+
+	inStyle := false
+	inScript := false
+
+	injected := !u.injectLiveReload
+
+	for {
+		tt := z.Next()
+
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return errors.WithStack(err)
+			}
+
+			if !injected {
+				if _, err := io.WriteString(w, liveReloadScript); err != nil {
+					return errors.WithStack(err)
+				}
+			}
+
+			return nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			raw := string(z.Raw())
+			token := z.Token()
+
+			if tt == html.StartTagToken {
+				inStyle = token.DataAtom == atom.Style
+				inScript = token.DataAtom == atom.Script
+			}
+
+			if isCSPMeta(token) {
+				continue
+			}
+
+			if rewriteTagAttributes(&token, u) {
+				raw = token.String()
+			}
+
+			if _, err := io.WriteString(w, raw); err != nil {
+				return errors.WithStack(err)
+			}
+		case html.EndTagToken:
+			inStyle = false
+			inScript = false
+			raw := string(z.Raw())
+
+			if !injected && z.Token().DataAtom == atom.Body {
+				injected = true
+				raw = liveReloadScript + raw
+			}
+
+			if _, err := io.WriteString(w, raw); err != nil {
+				return errors.WithStack(err)
+			}
+		case html.TextToken:
+			raw := z.Raw()
+
+			if inStyle {
+				raw = []byte(u.rewriteCSS(string(raw)))
+			} else if inScript {
+				raw = []byte(replaceHost(string(raw), u.originHost, u.proxyHost, u.useSSL, u.proxyExternal, u.proxyExternalIgnores))
+			}
+
+			if _, err := w.Write(raw); err != nil {
+				return errors.WithStack(err)
+			}
+		default:
+			if _, err := w.Write(z.Raw()); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+}
+
+// isCSPMeta reports whether the token is <meta http-equiv="Content-Security-Policy">
+func isCSPMeta(token html.Token) bool {
+	if token.DataAtom != atom.Meta {
+		return false
+	}
+
+	for _, attr := range token.Attr {
+		if attr.Key == "http-equiv" {
+			v := strings.ToLower(strings.TrimSpace(attr.Val))
+
+			return v == "content-security-policy" || v == "content-security-policy-report-only"
+		}
+	}
+
+	return false
+}
+
+// rewriteTagAttributes rewrites the attributes of tag, returns true if any attribute changed
+func rewriteTagAttributes(token *html.Token, u *urlRewriter) bool {
+	changed := false
+	attrs := make([]html.Attribute, 0, len(token.Attr))
+
+	isMetaRefresh := false
+
+	if token.DataAtom == atom.Meta {
+		for _, attr := range token.Attr {
+			if attr.Key == "http-equiv" && strings.EqualFold(strings.TrimSpace(attr.Val), "refresh") {
+				isMetaRefresh = true
+			}
+		}
+	}
+
+	for _, attr := range token.Attr {
+		val := attr.Val
+
+		if _, ok := urlAttributes[attr.Key]; ok {
+			val = u.rewrite(attr.Val)
+
+			if token.DataAtom == atom.Base && attr.Key == "href" && u.base != nil {
+				if ref, err := url.Parse(strings.TrimSpace(attr.Val)); err == nil {
+					u.base = u.base.ResolveReference(ref)
+				}
+			}
+		} else if _, ok := srcsetAttributes[attr.Key]; ok {
+			val = u.rewriteSrcset(attr.Val)
+		} else if attr.Key == "style" {
+			val = u.rewriteCSS(attr.Val)
+		} else if attr.Key == "content" && isMetaRefresh {
+			val = u.rewriteMetaRefresh(attr.Val)
+		} else if attr.Key == "integrity" {
+			// https://developer.mozilla.org/en-US/docs/Web/Security/Subresource_Integrity
+			changed = true
+			continue
+		}
+
+		if val != attr.Val {
+			changed = true
+			attr.Val = val
+		}
+
+		attrs = append(attrs, attr)
+	}
+
+	token.Attr = attrs
+
+	return changed
+}
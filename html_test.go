@@ -0,0 +1,87 @@
+package forward
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_rewriteHTML(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		pageURL    string
+		isProxyUrl bool
+		want       string
+	}{
+		{
+			name:    "url attributes",
+			content: `<a href="https://example.com/demo">demo</a><img src="//example.com/a.png">`,
+			want:    `<a href="http://localhost:8080/demo">demo</a><img src="//localhost:8080/a.png">`,
+		},
+		{
+			name:    "leave text content alone",
+			content: `<p>visit https://example.com/demo and integrity="abc"</p>`,
+			want:    `<p>visit https://example.com/demo and integrity="abc"</p>`,
+		},
+		{
+			name:    "srcset",
+			content: `<img srcset="https://example.com/a.png 1x,https://example.com/b.png 2x">`,
+			want:    `<img srcset="http://localhost:8080/a.png 1x, http://localhost:8080/b.png 2x">`,
+		},
+		{
+			name:    "base and meta refresh",
+			content: `<base href="https://example.com/"><meta http-equiv="refresh" content="5; url=https://example.com/next">`,
+			want:    `<base href="http://localhost:8080/"><meta http-equiv="refresh" content="5; url=http://localhost:8080/next">`,
+		},
+		{
+			name:    "style",
+			content: `<div style="background: url('https://example.com/bg.png')"></div><style>@import "https://example.com/a.css"; a { background: url(https://example.com/b.png) }</style>`,
+			want:    `<div style="background: url(&#39;http://localhost:8080/bg.png&#39;)"></div><style>@import "http://localhost:8080/a.css"; a { background: url(http://localhost:8080/b.png) }</style>`,
+		},
+		{
+			name:    "remove integrity and CSP meta element",
+			content: `<meta http-equiv="Content-Security-Policy" content="default-src 'self'"><script src="https://example.com/a.js" integrity="sha384-abc"></script>`,
+			want:    `<script src="http://localhost:8080/a.js"></script>`,
+		},
+		{
+			name:    "replace the hosts of inline script and leave text alone",
+			content: `<script>if (a < b) fetch("https://example.com/api", {body: "</p>"})</script><p>see https://example.com</p>`,
+			want:    `<script>if (a < b) fetch("http://localhost:8080/api", {body: "</p>"})</script><p>see https://example.com</p>`,
+		},
+		{
+			name:       "relative url of page proxied with forward_url",
+			content:    `<base href="/static/"><img src="a.png" srcset="b.png 2x"><a href="data:text/plain,a">data</a>`,
+			pageURL:    "https://cdn.example.org/index.html",
+			isProxyUrl: true,
+			want:       `<base href="http://localhost:8080/?forward_url=https%3A%2F%2Fcdn.example.org%2Fstatic%2F"><img src="http://localhost:8080/?forward_url=https%3A%2F%2Fcdn.example.org%2Fstatic%2Fa.png" srcset="http://localhost:8080/?forward_url=https%3A%2F%2Fcdn.example.org%2Fstatic%2Fb.png 2x"><a href="data:text/plain,a">data</a>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var base *url.URL
+
+			if tt.pageURL != "" {
+				base, _ = url.Parse(tt.pageURL)
+			}
+
+			buf := &bytes.Buffer{}
+
+			err := rewriteHTML(buf, strings.NewReader(tt.content), &urlRewriter{
+				originHost: "example.com",
+				proxyHost:  "localhost:8080",
+				base:       base,
+				isProxyUrl: tt.isProxyUrl,
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("rewriteHTML() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
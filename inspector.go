@@ -0,0 +1,413 @@
+package forward
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultInspectorSize        = 1000
+	defaultInspectorMaxBodySize = 1024 * 1024
+	// the bodies of the oldest entries are dropped if all the bodies kept are larger than it
+	maxInspectorBodiesSize = 64 * 1024 * 1024
+	// the diff is skipped if the bodies have too many changed lines to compare
+	maxDiffLines     = 2000
+	maxDiffSize      = 256 * 1024
+	diffContextLines = 3
+)
+
+//go:embed inspector.html
+var inspectorHTML []byte
+
+type inspectorEntry struct {
+	ID           uint64  `json:"id"`
+	Time         string  `json:"time"`
+	Method       string  `json:"method"`
+	URL          string  `json:"url"`
+	RewrittenURL string  `json:"rewrittenUrl,omitempty"`
+	Status       int     `json:"status"`
+	Latency      float64 `json:"latency"` // milliseconds
+	ContentType  string  `json:"contentType,omitempty"`
+	Error        string  `json:"error,omitempty"`
+	Rewritten    bool    `json:"rewritten,omitempty"` // whether the body of upstream is rewritten, the diff is served by '/diff?id=<id>'
+
+	// the bodies to diff, they are guarded by the mutex of inspector
+	original  []byte
+	rewritten []byte
+}
+
+// Inspector keeps the recent traffic passed through the proxy server in a ring buffer,
+// and serves a web UI to watch them in real time.
+type Inspector struct {
+	entries       []*inspectorEntry
+	next          int
+	id            uint64
+	maxBodySize   int
+	bodiesSize    int // the size of bodies kept by the entries
+	maxBodiesSize int
+	subscribers   map[chan *inspectorEntry]struct{}
+	mu            sync.Mutex
+}
+
+// NewInspector creates an inspector which keeps the last size entries.
+// the body larger than maxBodySize is truncated before diffing.
+func NewInspector(size int, maxBodySize int) *Inspector {
+	if size <= 0 {
+		size = defaultInspectorSize
+	}
+
+	if maxBodySize <= 0 {
+		maxBodySize = defaultInspectorMaxBodySize
+	}
+
+	return &Inspector{
+		entries:       make([]*inspectorEntry, size),
+		maxBodySize:   maxBodySize,
+		maxBodiesSize: maxInspectorBodiesSize,
+		subscribers:   map[chan *inspectorEntry]struct{}{},
+	}
+}
+
+func (i *Inspector) observe(e *exchange) {
+	e.mu.Lock()
+
+	entry := &inspectorEntry{
+		Time:        e.startedAt.Format(time.RFC3339Nano),
+		Method:      e.request.method,
+		URL:         e.request.url,
+		Status:      e.response.status,
+		Latency:     milliseconds(e.finishedAt.Sub(e.startedAt)),
+		ContentType: e.response.header.Get("Content-Type"),
+	}
+
+	if e.upstreamRequest != nil {
+		entry.RewrittenURL = e.upstreamRequest.url
+	}
+
+	if e.err != nil {
+		entry.Error = e.err.Error()
+	}
+
+	if e.upstreamResponse != nil && e.upstreamResponse.body != nil && e.response.body != nil {
+		// the bodies are captured up to the max size of all the observers, and they may be larger after decoding
+		original := truncateText(e.upstreamResponse.body.decoded(e.upstreamResponse.header.Get("Content-Encoding")), i.maxBodySize)
+		rewritten := truncateText(e.response.body.decoded(e.response.header.Get("Content-Encoding")), i.maxBodySize)
+
+		// the diff is computed when it is requested, so that it does not slow down the proxy
+		if !bytes.Equal(original, rewritten) && utf8.Valid(original) && utf8.Valid(rewritten) {
+			entry.Rewritten = true
+			// copy the bodies to release the spare capacity of buffers
+			entry.original = append([]byte(nil), original...)
+			entry.rewritten = append([]byte(nil), rewritten...)
+		}
+	}
+
+	e.mu.Unlock()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.id++
+	entry.ID = i.id
+
+	if old := i.entries[i.next]; old != nil {
+		i.dropBodies(old)
+	}
+
+	i.entries[i.next] = entry
+	i.next = (i.next + 1) % len(i.entries)
+	i.bodiesSize += len(entry.original) + len(entry.rewritten)
+
+	// drop the bodies from the oldest entry, the entries are kept
+	for n := 0; n < len(i.entries) && i.bodiesSize > i.maxBodiesSize; n++ {
+		if old := i.entries[(i.next+n)%len(i.entries)]; old != nil {
+			i.dropBodies(old)
+		}
+	}
+
+	for ch := range i.subscribers {
+		select {
+		case ch <- entry:
+		default:
+			// drop the entry for the slow subscriber
+		}
+	}
+}
+
+// truncateText truncates the text to the size at most, the UTF-8 character is not split
+func truncateText(b []byte, size int) []byte {
+	if len(b) <= size {
+		return b
+	}
+
+	b = b[:size]
+
+	// cut the incomplete character at the end
+	for n := 1; n <= utf8.UTFMax && n <= len(b); n++ {
+		if utf8.RuneStart(b[len(b)-n]) {
+			if !utf8.FullRune(b[len(b)-n:]) {
+				b = b[:len(b)-n]
+			}
+
+			break
+		}
+	}
+
+	return b
+}
+
+func (i *Inspector) dropBodies(entry *inspectorEntry) {
+	i.bodiesSize -= len(entry.original) + len(entry.rewritten)
+	entry.original = nil
+	entry.rewritten = nil
+}
+
+// diff returns the diff between the body of upstream and the rewritten body of entry
+func (i *Inspector) diff(id uint64) (string, bool) {
+	i.mu.Lock()
+
+	var entry *inspectorEntry
+
+	for _, e := range i.entries {
+		if e != nil && e.ID == id {
+			entry = e
+			break
+		}
+	}
+
+	if entry == nil || !entry.Rewritten {
+		i.mu.Unlock()
+		return "", false
+	}
+
+	original, rewritten := entry.original, entry.rewritten
+
+	i.mu.Unlock()
+
+	if original == nil && rewritten == nil {
+		return "the bodies are dropped to limit the memory, inspect a newer request", true
+	}
+
+	diff := diffLines(string(original), string(rewritten))
+
+	if len(diff) > maxDiffSize {
+		diff = diff[:maxDiffSize] + fmt.Sprintf("\n... the diff is truncated (%d bytes)", len(diff))
+	}
+
+	return diff, true
+}
+
+// list returns the entries in the ring buffer from oldest to newest
+func (i *Inspector) list() []*inspectorEntry {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	result := []*inspectorEntry{}
+
+	for n := 0; n < len(i.entries); n++ {
+		if entry := i.entries[(i.next+n)%len(i.entries)]; entry != nil {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+func (i *Inspector) subscribe() chan *inspectorEntry {
+	ch := make(chan *inspectorEntry, 64)
+
+	i.mu.Lock()
+	i.subscribers[ch] = struct{}{}
+	i.mu.Unlock()
+
+	return ch
+}
+
+func (i *Inspector) unsubscribe(ch chan *inspectorEntry) {
+	i.mu.Lock()
+	delete(i.subscribers, ch)
+	i.mu.Unlock()
+}
+
+// Handler serves the web UI of inspector
+func (i *Inspector) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(inspectorHTML)
+	})
+
+	mux.HandleFunc("/entries", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(i.list())
+	})
+
+	mux.HandleFunc("/diff", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+
+		diff, ok := i.diff(id)
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(diff))
+	})
+
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		ch := i.subscribe()
+		defer i.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(entry *inspectorEntry) error {
+			b, err := json.Marshal(entry)
+
+			if err != nil {
+				return nil
+			}
+
+			_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", entry.ID, b)
+
+			return err
+		}
+
+		// resend the entries missed while the client was reconnecting
+		lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+		if lastID > 0 {
+			for _, entry := range i.list() {
+				if entry.ID > lastID {
+					if err := send(entry); err != nil {
+						return
+					}
+					lastID = entry.ID
+				}
+			}
+		}
+
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case entry := <-ch:
+				if entry.ID <= lastID {
+					continue
+				}
+
+				if err := send(entry); err != nil {
+					return
+				}
+
+				flusher.Flush()
+			}
+		}
+	})
+
+	return mux
+}
+
+// diffLines compares two texts line by line, returns the changed lines prefixed with '-' or '+',
+// and the unchanged lines around them prefixed with ' '.
+func diffLines(a, b string) string {
+	x := strings.Split(a, "\n")
+	y := strings.Split(b, "\n")
+
+	// skip the common prefix and suffix, keep some lines as context
+	prefix := 0
+
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	var sb strings.Builder
+
+	for _, line := range x[maxInt(prefix-diffContextLines, 0):prefix] {
+		sb.WriteString(" " + line + "\n")
+	}
+
+	x, y, after := x[prefix:len(x)-suffix], y[prefix:len(y)-suffix], x[len(x)-suffix:]
+
+	if len(x) > maxDiffLines || len(y) > maxDiffLines {
+		return fmt.Sprintf("the bodies are too large to diff (%d and %d lines changed)", len(x), len(y))
+	}
+
+	// the length of longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int32, len(x)+1)
+
+	for i := range lcs {
+		lcs[i] = make([]int32, len(y)+1)
+	}
+
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			sb.WriteString(" " + x[i] + "\n")
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("-" + x[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+" + y[j] + "\n")
+			j++
+		}
+	}
+
+	if len(after) > diffContextLines {
+		after = after[:diffContextLines]
+	}
+
+	for _, line := range after {
+		sb.WriteString(" " + line + "\n")
+	}
+
+	return sb.String()
+}
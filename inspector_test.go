@@ -0,0 +1,137 @@
+package forward
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_diffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{
+			name: "replace a line",
+			a:    "a\nb\nc",
+			b:    "a\nB\nc",
+			want: " a\n-b\n+B\n c\n",
+		},
+		{
+			name: "insert a line",
+			a:    "a\nc",
+			b:    "a\nb\nc",
+			want: " a\n+b\n c\n",
+		},
+		{
+			name: "keep 3 lines of context",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9",
+			want: " 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.a, tt.b); got != tt.want {
+				t.Errorf("diffLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInspector_list(t *testing.T) {
+	i := NewInspector(2, 0)
+
+	for n := 0; n < 3; n++ {
+		i.observe(&exchange{})
+	}
+
+	entries := i.list()
+
+	if len(entries) != 2 || entries[0].ID != 2 || entries[1].ID != 3 {
+		t.Errorf("list() should keep the last 2 entries in order, got %+v", entries)
+	}
+}
+
+func TestInspector_diff(t *testing.T) {
+	observe := func(i *Inspector, original, rewritten string) {
+		e := &exchange{upstreamResponse: &capturedMessage{header: http.Header{}, body: newBodyCapture(1024)}}
+		e.response.header = http.Header{}
+		e.response.body = newBodyCapture(1024)
+
+		_, _ = e.upstreamResponse.body.Write([]byte(original))
+		_, _ = e.response.body.Write([]byte(rewritten))
+
+		i.observe(e)
+	}
+
+	i := NewInspector(10, 0)
+	i.maxBodiesSize = 50
+
+	observe(i, "a\nb", "a\nb")
+	observe(i, "a\nhttp://example.com", "a\nhttp://localhost")
+
+	entries := i.list()
+
+	if entries[0].Rewritten || !entries[1].Rewritten {
+		t.Fatalf("only the second entry is rewritten, got %+v", entries)
+	}
+
+	if diff, ok := i.diff(entries[1].ID); !ok || diff != " a\n-http://example.com\n+http://localhost\n" {
+		t.Errorf("diff() = %q, %v", diff, ok)
+	}
+
+	if _, ok := i.diff(entries[0].ID); ok {
+		t.Errorf("the entry which is not rewritten has no diff")
+	}
+
+	// the bodies of the oldest entry are dropped to limit the memory
+	observe(i, "b\nhttp://example.com", "b\nhttp://localhost")
+
+	if diff, _ := i.diff(entries[1].ID); !strings.Contains(diff, "dropped") {
+		t.Errorf("the bodies of the oldest entry should be dropped, got %q", diff)
+	}
+
+	if i.bodiesSize > i.maxBodiesSize {
+		t.Errorf("bodiesSize = %d, want <= %d", i.bodiesSize, i.maxBodiesSize)
+	}
+
+	// the bodies are truncated to the max body size of inspector
+	i = NewInspector(10, 4)
+
+	observe(i, "a\nb\nc", "a\nb\nd")
+	observe(i, "a\nb\nc", "x\nb\nd")
+
+	entries = i.list()
+
+	if entries[0].Rewritten {
+		t.Errorf("the truncated bodies are the same, got %+v", entries[0])
+	}
+
+	if diff, ok := i.diff(entries[1].ID); !ok || !strings.Contains(diff, "+x") || strings.Contains(diff, "d") {
+		t.Errorf("diff() = %q, %v", diff, ok)
+	}
+}
+
+func Test_truncateText(t *testing.T) {
+	tests := []struct {
+		text string
+		size int
+		want string
+	}{
+		{text: "hello", size: 10, want: "hello"},
+		{text: "hello", size: 3, want: "hel"},
+		{text: "a世界", size: 4, want: "a世"},
+		{text: "a世界", size: 3, want: "a"},
+		{text: "a世界", size: 5, want: "a世"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := string(truncateText([]byte(tt.text), tt.size)); got != tt.want {
+				t.Errorf("truncateText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package forward
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	liveReloadPath = "/__forward__/livereload"
+	// the changes in the period are notified once, the editors usually write a file several times
+	liveReloadDebounce = 100 * time.Millisecond
+)
+
+// the script reconnects to the proxy server, then reloads the page or hot-swaps the CSS when files change
+const liveReloadScript = `<script>(function () {
+  var delay = 1000;
+  function connect() {
+    var ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "` + liveReloadPath + `");
+    ws.onopen = function () { delay = 1000; };
+    ws.onmessage = function (event) {
+      if (event.data !== "css") { location.reload(); return; }
+      document.querySelectorAll('link[rel="stylesheet"]').forEach(function (link) {
+        var url = new URL(link.href);
+        url.searchParams.set("_livereload", Date.now());
+        link.href = url.toString();
+      });
+    };
+    ws.onclose = function () { setTimeout(connect, delay); delay = Math.min(delay * 2, 10000); };
+  }
+  connect();
+})();</script>`
+
+// liveReload watches the files of overwrite, and notifies the pages to reload over WebSocket
+type liveReload struct {
+	watcher *fsnotify.Watcher
+	clients map[*websocket.Conn]struct{}
+	mu      sync.Mutex
+}
+
+func newLiveReload(paths []string) (*liveReload, error) {
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	l := &liveReload{
+		watcher: watcher,
+		clients: map[*websocket.Conn]struct{}{},
+	}
+
+	for _, p := range paths {
+		info, err := os.Stat(p)
+
+		if err != nil {
+			_ = watcher.Close()
+			return nil, errors.WithStack(err)
+		}
+
+		// watch the directory of file, because the editors may replace the file when saving
+		if !info.IsDir() {
+			p = filepath.Dir(p)
+		}
+
+		if err := l.watchTree(p); err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+	}
+
+	go l.run()
+
+	return l, nil
+}
+
+// watchTree watches the directory and its sub directories, fsnotify is not recursive
+func (l *liveReload) watchTree(dir string) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") && p != dir {
+				return filepath.SkipDir
+			}
+
+			return errors.WithStack(l.watcher.Add(p))
+		}
+
+		return nil
+	})
+}
+
+func (l *liveReload) run() {
+	var (
+		timer   *time.Timer
+		changed = map[string]struct{}{}
+		mu      sync.Mutex
+	)
+
+	for {
+		select {
+		case event, ok := <-l.watcher.Events:
+			if !ok {
+				return
+			}
+
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					_ = l.watchTree(event.Name)
+				}
+			}
+
+			mu.Lock()
+			changed[event.Name] = struct{}{}
+
+			if timer == nil {
+				timer = time.AfterFunc(liveReloadDebounce, func() {
+					mu.Lock()
+					files := changed
+					changed = map[string]struct{}{}
+					timer = nil
+					mu.Unlock()
+
+					l.notify(files)
+				})
+			}
+			mu.Unlock()
+		case err, ok := <-l.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			log.Printf("failed to watch files: %+v\n", err)
+		}
+	}
+}
+
+// notify sends 'css' if only the CSS files changed, otherwise 'reload'
+func (l *liveReload) notify(files map[string]struct{}) {
+	message := "css"
+
+	for f := range files {
+		if !strings.EqualFold(filepath.Ext(f), ".css") {
+			message = "reload"
+			break
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for ws := range l.clients {
+		if err := websocket.Message.Send(ws, message); err != nil {
+			_ = ws.Close()
+			delete(l.clients, ws)
+		}
+	}
+}
+
+func (l *liveReload) serveWebSocket(ws *websocket.Conn) {
+	l.mu.Lock()
+	l.clients[ws] = struct{}{}
+	l.mu.Unlock()
+
+	// block until the page is closed
+	var message string
+
+	for websocket.Message.Receive(ws, &message) == nil {
+	}
+
+	l.mu.Lock()
+	delete(l.clients, ws)
+	l.mu.Unlock()
+}
+
+func (l *liveReload) handler() websocket.Handler {
+	return l.serveWebSocket
+}
+
+func (l *liveReload) close() error {
+	return errors.WithStack(l.watcher.Close())
+}
+
+// injectLiveReload inserts the script before '</body>', or appends it if not found
+func injectLiveReload(html []byte) []byte {
+	i := bytes.LastIndex(bytes.ToLower(html), []byte("</body>"))
+
+	if i < 0 {
+		return append(html, liveReloadScript...)
+	}
+
+	result := make([]byte, 0, len(html)+len(liveReloadScript))
+	result = append(result, html[:i]...)
+	result = append(result, liveReloadScript...)
+
+	return append(result, html[i:]...)
+}
@@ -0,0 +1,99 @@
+package forward
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func Test_injectLiveReload(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{name: "body", html: "<html><body>hi</BODY></html>", want: "<html><body>hi" + liveReloadScript + "</BODY></html>"},
+		{name: "no body", html: "<p>hi</p>", want: "<p>hi</p>" + liveReloadScript},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(injectLiveReload([]byte(tt.html))); got != tt.want {
+				t.Errorf("injectLiveReload() = %s, want %s", got, tt.want)
+			}
+
+			buf := &bytes.Buffer{}
+
+			if err := rewriteHTML(buf, strings.NewReader(tt.html), &urlRewriter{injectLiveReload: true}); err != nil {
+				t.Fatal(err)
+			}
+
+			if buf.String() != tt.want {
+				t.Errorf("rewriteHTML() = %s, want %s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyServer_liveReload(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "style.css"), []byte("body {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewProxyServer(&ProxyServerOptions{
+		OverwriteFolder: dir,
+		LiveReload:      true,
+	})
+	defer server.Close()
+
+	proxy := httptest.NewServer(http.HandlerFunc(server.Handler()))
+	defer proxy.Close()
+
+	ws, err := websocket.Dial(strings.Replace(proxy.URL, "http://", "ws://", 1)+liveReloadPath, "", proxy.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// wait for the connection is registered
+	time.Sleep(50 * time.Millisecond)
+
+	if err := os.Mkdir(filepath.Join(dir, "css"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var message string
+
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if err := websocket.Message.Receive(ws, &message); err != nil {
+		t.Fatal(err)
+	}
+
+	if message != "reload" {
+		t.Errorf("message = %s, want reload", message)
+	}
+
+	// the new directory is watched as well
+	if err := ioutil.WriteFile(filepath.Join(dir, "css", "app.css"), []byte("body {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := websocket.Message.Receive(ws, &message); err != nil {
+		t.Fatal(err)
+	}
+
+	if message != "css" {
+		t.Errorf("message = %s, want css", message)
+	}
+}
@@ -0,0 +1,166 @@
+package forward
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// RequestMiddleware handles the request before it is proxied.
+// The body of request is decoded, the 'Content-Encoding' header is removed.
+// Returns a non-nil response to respond the client without proxying, the remaining middlewares are skipped.
+type RequestMiddleware interface {
+	HandleRequest(req *http.Request) (*http.Response, error)
+}
+
+// ResponseMiddleware handles the response after it is rewritten by the proxy server.
+// The body of response is decoded, it is encoded again with the original encoding if the 'Content-Encoding' header is not set by middlewares.
+// Returns a non-nil response to replace the response, the remaining middlewares are skipped.
+type ResponseMiddleware interface {
+	HandleResponse(res *http.Response) (*http.Response, error)
+}
+
+// RequestMiddlewareFunc is an adapter to use the ordinary function as RequestMiddleware
+type RequestMiddlewareFunc func(req *http.Request) (*http.Response, error)
+
+func (f RequestMiddlewareFunc) HandleRequest(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// ResponseMiddlewareFunc is an adapter to use the ordinary function as ResponseMiddleware
+type ResponseMiddlewareFunc func(res *http.Response) (*http.Response, error)
+
+func (f ResponseMiddlewareFunc) HandleResponse(res *http.Response) (*http.Response, error) {
+	return f(res)
+}
+
+// handleRequestMiddlewares runs the request middlewares in order, returns the response if any of them responds
+func (p *ProxyServer) handleRequestMiddlewares(req *http.Request) (*http.Response, error) {
+	if len(p.RequestMiddlewares) == 0 {
+		return nil, nil
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		if encoding := req.Header.Get("Content-Encoding"); encoding != "" {
+			reader, ok, err := decodeBody(req.Body, encoding)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				req.Body = &readCloser{Reader: reader, Closer: req.Body}
+				req.Header.Del("Content-Encoding")
+				req.Header.Del("Content-Length")
+				req.ContentLength = -1
+			}
+		}
+	}
+
+	for _, m := range p.RequestMiddlewares {
+		res, err := m.HandleRequest(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if res != nil {
+			return res, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// handleResponseMiddlewares runs the response middlewares in order
+func (p *ProxyServer) handleResponseMiddlewares(res *http.Response) error {
+	// the body of upgrade response is a connection
+	if len(p.ResponseMiddlewares) == 0 || res.StatusCode == http.StatusSwitchingProtocols {
+		return nil
+	}
+
+	encoding := res.Header.Get("Content-Encoding")
+
+	reader, ok, err := decodeBody(res.Body, encoding)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		// the body can not be decoded, the middlewares receive the encoded body
+		encoding = ""
+	} else if encoding != "" {
+		res.Body = &readCloser{Reader: reader, Closer: res.Body}
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+	}
+
+	for _, m := range p.ResponseMiddlewares {
+		r, err := m.HandleResponse(res)
+
+		if err != nil {
+			return err
+		}
+
+		if r != nil {
+			if r.Body != res.Body {
+				_ = res.Body.Close()
+			}
+
+			if r.Request == nil {
+				r.Request = res.Request
+			}
+
+			// the proxy holds the pointer of response
+			*res = *r
+			break
+		}
+	}
+
+	if encoding != "" && encoding != "identity" && res.Header.Get("Content-Encoding") == "" {
+		res.Header.Set("Content-Encoding", encoding)
+
+		return streamBody(res, res.Body, encoding, func(w io.Writer, r io.Reader) error {
+			_, err := io.Copy(w, r)
+
+			return errors.WithStack(err)
+		})
+	}
+
+	return nil
+}
+
+// writeResponse writes the response of middleware to client
+func writeResponse(w http.ResponseWriter, res *http.Response) error {
+	for k, values := range res.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+
+	status := res.StatusCode
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	w.WriteHeader(status)
+
+	if res.Body == nil {
+		return nil
+	}
+
+	defer res.Body.Close()
+
+	_, err := io.Copy(w, res.Body)
+
+	return errors.WithStack(err)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,110 @@
+package forward
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProxyServer_middlewares(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "gzip")
+
+		gz := gzip.NewWriter(w)
+		_, _ = gz.Write([]byte("hello " + r.Header.Get("X-Name")))
+		_ = gz.Close()
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	order := []string{}
+
+	server := NewProxyServer(&ProxyServerOptions{
+		Target: target,
+		RequestMiddlewares: []RequestMiddleware{
+			RequestMiddlewareFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, "request 1")
+
+				if req.URL.Path == "/mock" {
+					return &http.Response{
+						StatusCode: http.StatusTeapot,
+						Header:     http.Header{},
+						Body:       ioutil.NopCloser(strings.NewReader("mocked")),
+					}, nil
+				}
+
+				req.Header.Set("X-Name", "world")
+
+				return nil, nil
+			}),
+			RequestMiddlewareFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, "request 2")
+				return nil, nil
+			}),
+		},
+		ResponseMiddlewares: []ResponseMiddleware{
+			ResponseMiddlewareFunc(func(res *http.Response) (*http.Response, error) {
+				order = append(order, "response 1")
+
+				body, err := ioutil.ReadAll(res.Body)
+
+				if err != nil {
+					return nil, err
+				}
+
+				res.Body = ioutil.NopCloser(strings.NewReader(strings.ToUpper(string(body))))
+
+				return nil, nil
+			}),
+		},
+	})
+	defer server.Close()
+
+	proxy := httptest.NewServer(http.HandlerFunc(server.Handler()))
+	defer proxy.Close()
+
+	res, err := http.Get(proxy.URL + "/")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+
+	if string(body) != "HELLO WORLD" {
+		t.Errorf("body = %s, want %s", body, "HELLO WORLD")
+	}
+
+	if !res.Uncompressed {
+		t.Errorf("the body should be encoded with the original encoding")
+	}
+
+	if strings.Join(order, ",") != "request 1,request 2,response 1" {
+		t.Errorf("order = %v", order)
+	}
+
+	order = []string{}
+
+	res, err = http.Get(proxy.URL + "/mock")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ = ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusTeapot || string(body) != "mocked" {
+		t.Errorf("the response of middleware should be responded, got %d %s", res.StatusCode, body)
+	}
+
+	if strings.Join(order, ",") != "request 1" {
+		t.Errorf("the remaining middlewares should be skipped, order = %v", order)
+	}
+}
@@ -0,0 +1,189 @@
+package forward
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Mock responds the matched requests without proxying
+type Mock struct {
+	Method   string        // match the request method, empty matches any method
+	Path     string        // match the request path, eg. '/api/users/:id' or '/api/*'
+	Status   int           // the status code of response. defaults to 200
+	Header   http.Header   // the headers of response
+	Body     string        // the inline body of response
+	BodyFile string        // read the body of response from the file, it is read for each request
+	Template bool          // render the body as a text/template with the request, eg. '{{.Params.id}}'
+	Delay    time.Duration // simulate the latency of response
+}
+
+// mockRequest is the data of mock template
+type mockRequest struct {
+	Method string
+	Path   string
+	Params map[string]string // the params in path, eg. ':id' and '*'
+	Query  url.Values
+	Header http.Header
+	Body   string
+}
+
+// match returns the path params if the request matches
+func (m *Mock) match(r *http.Request) (map[string]string, bool) {
+	if m.Method != "" && !strings.EqualFold(m.Method, r.Method) {
+		return nil, false
+	}
+
+	return matchPathPattern(m.Path, r.URL.Path)
+}
+
+// matchPathPattern matches the path with pattern, ':name' matches a segment and '*' matches the rest of path
+func matchPathPattern(pattern string, path string) (map[string]string, bool) {
+	patterns := strings.Split(strings.Trim(pattern, "/"), "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	params := map[string]string{}
+
+	for i, p := range patterns {
+		if p == "*" {
+			params["*"] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+
+		if i >= len(segments) {
+			return nil, false
+		}
+
+		if strings.HasPrefix(p, ":") {
+			if segments[i] == "" {
+				return nil, false
+			}
+
+			params[p[1:]], _ = url.PathUnescape(segments[i])
+		} else if p != segments[i] {
+			return nil, false
+		}
+	}
+
+	if len(patterns) != len(segments) {
+		return nil, false
+	}
+
+	return params, true
+}
+
+func (p *ProxyServer) matchMock(r *http.Request) (*Mock, map[string]string) {
+	for _, m := range p.Mocks {
+		if params, ok := m.match(r); ok {
+			return m, params
+		}
+	}
+
+	return nil, nil
+}
+
+func (p *ProxyServer) serveMock(w http.ResponseWriter, r *http.Request, m *Mock, params map[string]string) {
+	body, err := m.render(r, params)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("%+v\n", err)))
+		return
+	}
+
+	if m.Delay > 0 {
+		select {
+		case <-time.After(m.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	for k, values := range m.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+
+	if w.Header().Get("Content-Type") == "" && m.BodyFile != "" {
+		if mimeType := mime.TypeByExtension(filepath.Ext(m.BodyFile)); mimeType != "" {
+			w.Header().Set("Content-Type", mimeType)
+		}
+	}
+
+	if p.Cors {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	status := m.Status
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	w.WriteHeader(status)
+
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(body)
+	}
+}
+
+// render returns the body of mock
+func (m *Mock) render(r *http.Request, params map[string]string) ([]byte, error) {
+	body := []byte(m.Body)
+
+	if m.BodyFile != "" {
+		b, err := ioutil.ReadFile(m.BodyFile)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		body = b
+	}
+
+	if !m.Template {
+		return body, nil
+	}
+
+	tpl, err := template.New(m.Path).Parse(string(body))
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid template of mock '%s'", m.Path)
+	}
+
+	data := &mockRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Params: params,
+		Query:  r.URL.Query(),
+		Header: r.Header,
+	}
+
+	if r.Body != nil {
+		b, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		data.Body = string(b)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err := tpl.Execute(buf, data); err != nil {
+		return nil, errors.Wrapf(err, "failed to render the template of mock '%s'", m.Path)
+	}
+
+	return buf.Bytes(), nil
+}
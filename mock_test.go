@@ -0,0 +1,77 @@
+package forward
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_matchPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    map[string]string
+		wantOk  bool
+	}{
+		{pattern: "/api/users", path: "/api/users", want: map[string]string{}, wantOk: true},
+		{pattern: "/api/users", path: "/api/users/", want: map[string]string{}, wantOk: true},
+		{pattern: "/api/users", path: "/api/users/1", wantOk: false},
+		{pattern: "/api/users/:id", path: "/api/users/1", want: map[string]string{"id": "1"}, wantOk: true},
+		{pattern: "/api/users/:id", path: "/api/users", wantOk: false},
+		{pattern: "/api/:name/:id", path: "/api/posts/a%20b", want: map[string]string{"name": "posts", "id": "a b"}, wantOk: true},
+		{pattern: "/static/*", path: "/static/js/app.js", want: map[string]string{"*": "js/app.js"}, wantOk: true},
+		{pattern: "/", path: "/", want: map[string]string{}, wantOk: true},
+		{pattern: "/", path: "/foo", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			got, ok := matchPathPattern(tt.pattern, tt.path)
+
+			if ok != tt.wantOk {
+				t.Fatalf("matchPathPattern() ok = %v, want %v", ok, tt.wantOk)
+			}
+
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchPathPattern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyServer_mocks(t *testing.T) {
+	server := NewProxyServer(&ProxyServerOptions{
+		Mocks: []*Mock{
+			{
+				Method:   http.MethodPost,
+				Path:     "/api/users/:id",
+				Status:   http.StatusCreated,
+				Header:   http.Header{"Content-Type": []string{"application/json"}},
+				Body:     `{"id":"{{.Params.id}}","name":"{{.Query.Get "name"}}","body":{{.Body}}}`,
+				Template: true,
+			},
+		},
+	})
+
+	w := httptest.NewRecorder()
+	server.Handler()(w, httptest.NewRequest(http.MethodPost, "/api/users/1?name=foo", strings.NewReader(`{"a":1}`)))
+
+	body, _ := ioutil.ReadAll(w.Body)
+
+	if w.Code != http.StatusCreated || string(body) != `{"id":"1","name":"foo","body":{"a":1}}` {
+		t.Errorf("mock response = %d %s", w.Code, body)
+	}
+
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %s", w.Header().Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	server.Handler()(w, httptest.NewRequest(http.MethodGet, "/api/users/1", nil))
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("the request which does not match the mock should be proxied, got %d", w.Code)
+	}
+}
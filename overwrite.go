@@ -0,0 +1,278 @@
+package forward
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const indexFileName = "index.html"
+
+const (
+	OverwriteGlob  = "glob"  // '*' matches a part of segment, '**' matches any path, '?' matches a character
+	OverwriteRegex = "regex" // the regular expression matches the whole path
+)
+
+// OverwriteRule maps the requests to a local file or directory.
+// The wildcards of glob and the groups of regex are captured, '$1' or '${name}' in target refers to them.
+// If the target is a directory, the file of the last capture in it is served, eg. '/static/**' -> './dist'.
+type OverwriteRule struct {
+	Type    string // 'glob' or 'regex'. defaults to 'glob'
+	Pattern string // match the request path, eg. '/static/js/app.*.js'
+	Target  string // the local file or directory, eg. './dist/app.js'
+
+	regexp *regexp.Regexp
+}
+
+// Compile parses the pattern of rule
+func (o *OverwriteRule) Compile() error {
+	if o.regexp != nil {
+		return nil
+	}
+
+	if o.Pattern == "" || o.Target == "" {
+		return errors.New("the pattern and target of overwrite rule can not be empty")
+	}
+
+	expr := o.Pattern
+
+	switch o.Type {
+	case "", OverwriteGlob:
+		expr = globToRegexp(o.Pattern)
+	case OverwriteRegex:
+	default:
+		return errors.Errorf("invalid overwrite type '%s', it must be '%s' or '%s'", o.Type, OverwriteGlob, OverwriteRegex)
+	}
+
+	reg, err := regexp.Compile("^(?:" + expr + ")$")
+
+	if err != nil {
+		return errors.Wrapf(err, "invalid pattern '%s'", o.Pattern)
+	}
+
+	o.regexp = reg
+
+	return nil
+}
+
+// resolve returns the local path of the request path, returns false if not matched
+func (o *OverwriteRule) resolve(urlPath string) (string, string, bool) {
+	if o.regexp == nil {
+		return "", "", false
+	}
+
+	match := o.regexp.FindStringSubmatchIndex(urlPath)
+
+	if match == nil {
+		return "", "", false
+	}
+
+	target := string(o.regexp.ExpandString(nil, o.Target, urlPath, match))
+	rest := ""
+
+	// the last captured group which is matched
+	for i := len(match)/2 - 1; i > 0; i-- {
+		if match[2*i] >= 0 {
+			rest = urlPath[match[2*i]:match[2*i+1]]
+			break
+		}
+	}
+
+	return target, rest, true
+}
+
+// globToRegexp converts the glob to regular expression, the wildcards are captured
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString("(.*)")
+			i++
+		case glob[i] == '*':
+			sb.WriteString("([^/]*)")
+		case glob[i] == '?':
+			sb.WriteString("([^/])")
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	return sb.String()
+}
+
+// overwritePaths returns the local paths of overwrite, the paths with captures are resolved to the static part
+func (p *ProxyServer) overwritePaths() []string {
+	var paths []string
+
+	if p.OverwriteFolder != "" {
+		paths = append(paths, p.OverwriteFolder)
+	}
+
+	for _, rule := range p.OverwriteRules {
+		target := rule.Target
+
+		if i := strings.Index(target, "$"); i >= 0 {
+			target = filepath.Dir(target[:i] + "_")
+		}
+
+		if _, err := os.Stat(target); err == nil {
+			paths = append(paths, target)
+		}
+	}
+
+	return paths
+}
+
+// lookupOverwriteRule returns the file of the first matched rule
+func (p *ProxyServer) lookupOverwriteRule(urlPath string) (string, os.FileInfo, error) {
+	// the captures can not escape the target directory
+	if strings.Contains(urlPath+"/", "/../") {
+		return "", nil, nil
+	}
+
+	for _, rule := range p.OverwriteRules {
+		target, rest, ok := rule.resolve(urlPath)
+
+		if !ok {
+			continue
+		}
+
+		info, err := os.Stat(target)
+
+		if err == nil && info.IsDir() {
+			return lookupFile(target, rest)
+		}
+
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return "", nil, errors.WithStack(err)
+		}
+
+		return target, info, nil
+	}
+
+	return "", nil, nil
+}
+
+// serveOverwrite serves the file in overwrite folder like a static file server.
+// returns false if the file is not found, then the request should be proxied.
+func (p *ProxyServer) serveOverwrite(w http.ResponseWriter, r *http.Request) bool {
+	filePath, info, err := p.lookupOverwriteRule(r.URL.Path)
+
+	if err == nil && info == nil && p.OverwriteFolder != "" {
+		filePath, info, err = lookupFile(p.OverwriteFolder, r.URL.Path)
+	}
+
+	if err == nil && info == nil && p.OverwriteFolder != "" && p.OverwriteFallback != "" && isNavigationRequest(r) {
+		// the path of single page application is handled by the fallback file
+		filePath, info, err = lookupFile(p.OverwriteFolder, p.OverwriteFallback)
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("%+v\n", err)))
+		return true
+	}
+
+	if info == nil {
+		return false
+	}
+
+	f, err := os.Open(filePath)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("%+v\n", errors.WithStack(err))))
+		return true
+	}
+
+	defer f.Close()
+
+	// the content is sniffed by http.ServeContent if the extension is unknown
+	if mimeType := mime.TypeByExtension(filepath.Ext(filePath)); mimeType != "" {
+		w.Header().Set("Content-Type", mimeType)
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf(`W/"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+
+	if p.Cors {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	var content io.ReadSeeker = f
+
+	if p.liveReload != nil && strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		b, err := ioutil.ReadAll(f)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(fmt.Sprintf("%+v\n", errors.WithStack(err))))
+			return true
+		}
+
+		content = bytes.NewReader(injectLiveReload(b))
+	}
+
+	// it handles Range, HEAD, If-Modified-Since and If-None-Match
+	http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+
+	return true
+}
+
+// lookupFile returns the file of the path in folder, the index file is used for the directory.
+// the info is nil if the file is not found.
+func lookupFile(folder string, urlPath string) (string, os.FileInfo, error) {
+	// the cleaned path can not escape the folder
+	filePath := filepath.Join(folder, filepath.FromSlash(path.Clean("/"+urlPath)))
+
+	info, err := os.Stat(filePath)
+
+	if err == nil && info.IsDir() {
+		filePath = filepath.Join(filePath, indexFileName)
+		info, err = os.Stat(filePath)
+	}
+
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENAMETOOLONG) || errors.Is(err, syscall.ENOTDIR) {
+			return "", nil, nil
+		}
+
+		return "", nil, errors.WithStack(err)
+	}
+
+	if info.IsDir() {
+		return "", nil, nil
+	}
+
+	return filePath, info, nil
+}
+
+// isNavigationRequest reports whether the request is sent by browser to load a page
+func isNavigationRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
@@ -0,0 +1,159 @@
+package forward
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProxyServer_serveOverwrite(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"index.html":      "<html>home</html>",
+		"docs/index.html": "<html>docs</html>",
+		"app.js":          "console.log(1)",
+		"data":            "0123456789",
+	}
+
+	for name, content := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := NewProxyServer(&ProxyServerOptions{
+		OverwriteFolder:   dir,
+		OverwriteFallback: "index.html",
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     http.Header
+		wantStatus int
+		wantBody   string
+		wantServed bool
+	}{
+		{name: "file", method: "GET", path: "/app.js", wantStatus: 200, wantBody: "console.log(1)", wantServed: true},
+		{name: "head", method: "HEAD", path: "/app.js", wantStatus: 200, wantBody: "", wantServed: true},
+		{name: "post", method: "POST", path: "/app.js", wantStatus: 200, wantBody: "console.log(1)", wantServed: true},
+		{name: "directory index", method: "GET", path: "/docs/", wantStatus: 200, wantBody: "<html>docs</html>", wantServed: true},
+		{name: "range", method: "GET", path: "/data", header: http.Header{"Range": []string{"bytes=2-4"}}, wantStatus: 206, wantBody: "234", wantServed: true},
+		{name: "traversal", method: "GET", path: "/../../etc/passwd", wantServed: false},
+		{name: "not found", method: "GET", path: "/api/users", wantServed: false},
+		{name: "spa fallback", method: "GET", path: "/users/1", header: http.Header{"Accept": []string{"text/html"}}, wantStatus: 200, wantBody: "<html>home</html>", wantServed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			r.URL.Path = tt.path
+
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+
+			w := httptest.NewRecorder()
+
+			if served := server.serveOverwrite(w, r); served != tt.wantServed {
+				t.Fatalf("serveOverwrite() = %v, want %v", served, tt.wantServed)
+			}
+
+			if !tt.wantServed {
+				return
+			}
+
+			if w.Code != tt.wantStatus || w.Body.String() != tt.wantBody {
+				t.Errorf("serveOverwrite() = %d %s, want %d %s", w.Code, w.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+
+	// conditional request
+	w := httptest.NewRecorder()
+	server.serveOverwrite(w, httptest.NewRequest("GET", "/app.js", nil))
+
+	r := httptest.NewRequest("GET", "/app.js", nil)
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	server.serveOverwrite(w, r)
+
+	if w.Code != http.StatusNotModified {
+		t.Errorf("the conditional request should respond 304, got %d", w.Code)
+	}
+}
+
+func TestOverwriteRule_resolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       OverwriteRule
+		path       string
+		wantTarget string
+		wantRest   string
+		wantOk     bool
+	}{
+		{
+			name:       "glob",
+			rule:       OverwriteRule{Pattern: "/static/js/app.*.js", Target: "./dist/app.js"},
+			path:       "/static/js/app.3f2a1b.js",
+			wantTarget: "./dist/app.js",
+			wantRest:   "3f2a1b",
+			wantOk:     true,
+		},
+		{
+			name:   "glob does not match across segments",
+			rule:   OverwriteRule{Pattern: "/static/*.js", Target: "./dist/app.js"},
+			path:   "/static/js/app.js",
+			wantOk: false,
+		},
+		{
+			name:       "glob directory",
+			rule:       OverwriteRule{Pattern: "/static/**", Target: "./dist"},
+			path:       "/static/js/app.js",
+			wantTarget: "./dist",
+			wantRest:   "js/app.js",
+			wantOk:     true,
+		},
+		{
+			name:       "regex with captures",
+			rule:       OverwriteRule{Type: OverwriteRegex, Pattern: `/assets/(?P<name>\w+)\.[0-9a-f]+\.(css|js)`, Target: "./build/${name}.$2"},
+			path:       "/assets/main.8a9b.css",
+			wantTarget: "./build/main.css",
+			wantRest:   "css",
+			wantOk:     true,
+		},
+		{
+			name:   "regex matches the whole path",
+			rule:   OverwriteRule{Type: OverwriteRegex, Pattern: `/app\.js`, Target: "./app.js"},
+			path:   "/static/app.js",
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.Compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			target, rest, ok := tt.rule.resolve(tt.path)
+
+			if ok != tt.wantOk {
+				t.Fatalf("resolve() ok = %v, want %v", ok, tt.wantOk)
+			}
+
+			if target != tt.wantTarget || rest != tt.wantRest {
+				t.Errorf("resolve() = %s, %s, want %s, %s", target, rest, tt.wantTarget, tt.wantRest)
+			}
+		})
+	}
+}
@@ -2,73 +2,243 @@ package forward
 
 import (
 	"bytes"
-	"compress/gzip"
-	"compress/zlib"
 	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"mime"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"os"
-	"path/filepath"
-	"regexp"
 	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/andybalholm/brotli"
 	"github.com/pkg/errors"
 )
 
-var (
-	regIntegrity = regexp.MustCompile(`\sintegrity="[^"]+"`)
-)
-
 const (
 	headerXProxyTarget = "X-Proxy-Target"
 	headerXOriginHost  = "X-Origin-Host"
 	headerXProxyClient = "X-Proxy-Client"
+	// the subject of the certificate verified by the proxy server, the header from client is removed
+	headerXClientCertSubject = "X-Client-Cert-Subject"
 )
 
+type contextKey struct {
+	name string
+}
+
+var proxyContextKey = &contextKey{"proxy"}
+
+// proxyContext is the state of a proxied request
+type proxyContext struct {
+	route    *Route
+	upstream *upstream
+	backend  *backend
+	path     string // the path of request received by proxy server
+	grpcWeb  *grpcWebRequest
+}
+
+func getProxyContext(r *http.Request) *proxyContext {
+	return r.Context().Value(proxyContextKey).(*proxyContext)
+}
+
 type ProxyServer struct {
 	*ProxyServerOptions
-	proxy *httputil.ReverseProxy
+	proxy        *httputil.ReverseProxy
+	defaultRoute *Route
+	upstreams    map[*Route]*upstream
+	observers    []trafficObserver
+	// the max size of body to capture for the observers
+	maxCaptureBodySize int
+	liveReload         *liveReload
 }
 
 type ProxyServerOptions struct {
-	Target               *url.URL    // proxy target
-	UseSSL               bool        // use SSL
-	ReqHeaders           http.Header // set request headers
-	ResHeaders           http.Header // set response headers
-	ProxyExternal        bool        // whether to proxy external host
-	ProxyExternalIgnores []string    // the host name that should ignore when enable proxy external
-	Cors                 bool        // whether enable cors
-	NoCache              bool        // disabled cache for response
-	OverwriteFolder      string      // overwrite request with paths
+	Target                *url.URL             // proxy target, used when no route matched
+	Pool                  *UpstreamPool        // load balance between backends instead of the single target
+	Routes                []*Route             // routing table, the first matched route will be used
+	UseSSL                bool                 // use SSL
+	ReqHeaders            http.Header          // set request headers of all the routes
+	ResHeaders            http.Header          // set response headers of all the routes
+	ProxyExternal         bool                 // whether to proxy external host
+	ProxyExternalIgnores  []string             // the host name that should ignore when enable proxy external
+	Cors                  bool                 // whether enable cors
+	NoCache               bool                 // disabled cache for response
+	OverwriteFolder       string               // overwrite request with paths
+	OverwriteFallback     string               // the file in overwrite folder to serve the pages not found, eg. 'index.html' for single page application
+	OverwriteRules        []*OverwriteRule     // map the requests to local files, they take precedence over the overwrite folder
+	LiveReload            bool                 // reload the pages when the files of overwrite change
+	HAR                   *HARRecorder         // record the traffic to HAR file
+	Inspector             *Inspector           // watch the traffic with web UI
+	RecordDir             string               // record the responses of upstream into the folder
+	ReplayDir             string               // serve the recorded responses in the folder without requesting upstream
+	ReplayFallback        string               // the behavior when the response is not recorded, '404', 'passthrough' or 'error'. defaults to '404'
+	RecordMatchBody       bool                 // distinguish the recorded responses by the hash of request body
+	MaxRewriteSize        int64                // skip rewriting the response which Content-Length is larger than it, 0 means no limit
+	RequestMiddlewares    []RequestMiddleware  // handle the request in order before proxying
+	ResponseMiddlewares   []ResponseMiddleware // handle the response in order after rewriting
+	ReplaceRules          []*ReplaceRule       // substitute the content of response body after rewriting
+	Mocks                 []*Mock              // respond the matched requests without proxying, the first matched mock will be used
+	GRPCWeb               bool                 // translate the gRPC-Web requests of browsers to gRPC, the gRPC requests are always proxied with HTTP/2
+	Transport             *TransportOptions    // tune the transport to upstream, eg. the client certificates
+	UpstreamProtocol      string               // the protocol to upstream, 'auto', 'http1', 'http2' or 'h2c'. defaults to 'auto'
+	FlushInterval         time.Duration        // flush the response to client in the interval while copying, the streaming responses are flushed immediately
+	RewriteEventStream    bool                 // rewrite the hosts in the events of Server-Sent Events
+	WebSocketLogger       *WebSocketLogger     // capture the frames of WebSocket connections to a file
+	WebSocketIdleTimeout  time.Duration        // close the WebSocket connection without frames in the duration, 0 means no timeout
+	WebSocketPingInterval time.Duration        // send ping frames to the WebSocket clients in the interval, 0 means disabled
+}
+
+// Route proxies the requests that match the conditions to its own target.
+// The empty conditions match any request.
+type Route struct {
+	PathPrefix   string        // match the request which path starts with prefix
+	Host         string        // match the Host header of request, eg. 'example.com' or '*.example.com'
+	Method       string        // match the request method
+	Target       *url.URL      // proxy target
+	Pool         *UpstreamPool // load balance between backends instead of the single target
+	StripPrefix  bool          // strip the path prefix before proxying
+	ReqHeaders   http.Header   // set request headers, they override the global headers with the same key
+	ResHeaders   http.Header   // set response headers, they override the global headers with the same key
+	CookieDomain string        // overwrite the domain of cookies, defaults to the host name of proxy server
+	WebSocket    bool          // match the WebSocket handshake only, so that the upgrades can be proxied to another target
+}
+
+func (r *Route) match(req *http.Request) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, req.Method) {
+		return false
+	}
+
+	if r.WebSocket && !isWebSocket(req) {
+		return false
+	}
+
+	if r.Host != "" {
+		hostName := req.Host
+
+		if h, _, err := net.SplitHostPort(req.Host); err == nil {
+			hostName = h
+		}
+
+		if !matchHostName(r.Host, hostName) {
+			return false
+		}
+	}
+
+	if r.PathPrefix != "" && !hasPathPrefix(req.URL.Path, r.PathPrefix) {
+		return false
+	}
+
+	return true
 }
 
 func NewProxyServer(options *ProxyServerOptions) *ProxyServer {
-	proxy := httputil.NewSingleHostReverseProxy(options.Target)
+	proxy := &httputil.ReverseProxy{}
 
 	server := &ProxyServer{
-		options,
-		proxy,
+		ProxyServerOptions: options,
+		proxy:              proxy,
+		upstreams:          map[*Route]*upstream{},
+	}
+
+	routes := options.Routes
+
+	if options.Target != nil || options.Pool != nil {
+		server.defaultRoute = &Route{
+			Target: options.Target,
+			Pool:   options.Pool,
+		}
+
+		routes = append(routes, server.defaultRoute)
+	}
+
+	transport := newTransport(options)
+
+	healthCheckClient := &http.Client{Transport: transport}
+
+	for _, route := range routes {
+		pool := route.Pool
+
+		if pool == nil {
+			pool = &UpstreamPool{Targets: []*url.URL{route.Target}}
+		}
+
+		u := newUpstream(pool)
+
+		// the upstream is not contacted in replay mode
+		if options.ReplayDir == "" {
+			u.startHealthCheck(healthCheckClient)
+		}
+
+		server.upstreams[route] = u
+	}
+
+	for _, rule := range options.OverwriteRules {
+		if err := rule.Compile(); err != nil {
+			log.Printf("ignore the invalid overwrite rule: %+v\n", err)
+		}
+	}
+
+	if options.LiveReload {
+		l, err := newLiveReload(server.overwritePaths())
+
+		if err != nil {
+			log.Printf("failed to enable live reload: %+v\n", err)
+		} else {
+			server.liveReload = l
+		}
 	}
 
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
-		server.modifyRequest(req)
+	for _, rule := range options.ReplaceRules {
+		if err := rule.Compile(); err != nil {
+			log.Printf("ignore the invalid replace rule: %+v\n", err)
+		}
+	}
+
+	if options.HAR != nil {
+		server.observers = append(server.observers, options.HAR)
+		server.maxCaptureBodySize = maxInt(server.maxCaptureBodySize, options.HAR.maxBodySize)
 	}
 
+	if options.Inspector != nil {
+		server.observers = append(server.observers, options.Inspector)
+		server.maxCaptureBodySize = maxInt(server.maxCaptureBodySize, options.Inspector.maxBodySize)
+	}
+
+	if options.ReplayDir != "" {
+		proxy.Transport = &replayTransport{
+			transport: transport,
+			dir:       options.ReplayDir,
+			matchBody: options.RecordMatchBody,
+			fallback:  options.ReplayFallback,
+		}
+	} else if options.RecordDir != "" {
+		proxy.Transport = &recordTransport{
+			transport: transport,
+			dir:       options.RecordDir,
+			matchBody: options.RecordMatchBody,
+		}
+	} else {
+		proxy.Transport = transport
+	}
+
+	proxy.FlushInterval = options.FlushInterval
+	proxy.Director = server.modifyRequest
+
 	proxy.ModifyResponse = server.modifyResponse
 	proxy.ErrorHandler = func(rw http.ResponseWriter, r *http.Request, err error) {
 		if errors.Is(err, context.Canceled) {
 			return
 		}
+		if ctx, ok := r.Context().Value(proxyContextKey).(*proxyContext); ok {
+			ctx.upstream.reportError(ctx.backend, err)
+		}
+		if e := getExchange(r); e != nil {
+			e.captureError(err)
+		}
 		msg := fmt.Sprintf("%+v\n", err)
 		log.Println(msg)
 		rw.WriteHeader(http.StatusInternalServerError)
@@ -78,68 +248,135 @@ func NewProxyServer(options *ProxyServerOptions) *ProxyServer {
 	return server
 }
 
-func (p *ProxyServer) Handler() func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if p.OverwriteFolder != "" && r.Method == http.MethodGet {
-			paths := []string{p.OverwriteFolder}
-			paths = append(paths, strings.Split(strings.TrimLeft(r.URL.Path, "/"), "/")...)
+func (p *ProxyServer) matchRoute(r *http.Request) *Route {
+	for _, route := range p.Routes {
+		if route.match(r) {
+			return route
+		}
+	}
 
-			proxyFilePath := filepath.Join(paths...)
+	return p.defaultRoute
+}
 
-			fInfo, err := os.Stat(proxyFilePath)
+func (p *ProxyServer) serveProxy(w http.ResponseWriter, r *http.Request) {
+	res, err := p.handleRequestMiddlewares(r)
 
-			// proxy request if file is not exist
-			if os.IsNotExist(err) {
-				p.proxy.ServeHTTP(w, r)
-				return
-			}
+	if err != nil {
+		p.proxy.ErrorHandler(w, r, err)
+		return
+	}
 
-			if err != nil {
-				if strings.Contains(err.Error(), "file name too long") {
-					p.proxy.ServeHTTP(w, r)
-					return
-				} else {
-					w.WriteHeader(http.StatusInternalServerError)
-					w.Write([]byte(fmt.Sprintf("%+v\n", errors.WithStack(err))))
-					return
-				}
-			}
+	if res != nil {
+		if err := writeResponse(w, res); err != nil {
+			log.Printf("failed to write response: %+v\n", err)
+		}
+		return
+	}
 
-			if fInfo.IsDir() {
-				p.proxy.ServeHTTP(w, r)
-				return
-			}
+	route := p.matchRoute(r)
 
-			f, err := os.Open(proxyFilePath)
+	if route == nil {
+		http.Error(w, fmt.Sprintf("no route matched for '%s %s'", r.Method, r.URL.Path), http.StatusBadGateway)
+		return
+	}
 
-			// proxy request if file is not exist
-			if os.IsNotExist(err) {
-				p.proxy.ServeHTTP(w, r)
-				return
-			}
+	u := p.upstreams[route]
+	b, err := u.pick(r)
 
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(fmt.Sprintf("%+v\n", errors.WithStack(err))))
-				return
-			}
+	if err != nil {
+		// the availability of backends does not matter in replay mode, the recorded responses are served anyway
+		if p.ReplayDir == "" || len(u.backends) == 0 {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		b = u.backends[0]
+	}
+
+	atomic.AddInt64(&b.conns, 1)
+	defer atomic.AddInt64(&b.conns, -1)
+
+	ctx := &proxyContext{
+		route:    route,
+		upstream: u,
+		backend:  b,
+		path:     r.URL.Path,
+	}
+
+	if p.GRPCWeb {
+		ctx.grpcWeb = translateGRPCWebRequest(r)
+	}
 
-			defer f.Close()
+	p.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), proxyContextKey, ctx)))
+}
 
-			MIMEType := mime.TypeByExtension(filepath.Ext(proxyFilePath))
+// Close stops the background jobs of proxy server, eg. health check
+func (p *ProxyServer) Close() {
+	for _, u := range p.upstreams {
+		u.stopHealthCheck()
+	}
 
-			w.Header().Set("Content-Type", MIMEType)
-			w.WriteHeader(http.StatusOK)
+	if p.liveReload != nil {
+		_ = p.liveReload.close()
+	}
+}
 
-			_, _ = io.Copy(w, f)
+func (p *ProxyServer) Handler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(p.observers) > 0 {
+			p.serveWithCapture(w, r, p.handle)
 		} else {
-			p.proxy.ServeHTTP(w, r)
+			p.handle(w, r)
 		}
 	}
 }
 
+func (p *ProxyServer) handle(w http.ResponseWriter, r *http.Request) {
+	if p.liveReload != nil && r.URL.Path == liveReloadPath {
+		p.liveReload.handler().ServeHTTP(w, r)
+		return
+	}
+
+	if m, params := p.matchMock(r); m != nil {
+		p.serveMock(w, r, m, params)
+		return
+	}
+
+	if (p.OverwriteFolder != "" || len(p.OverwriteRules) > 0) && p.serveOverwrite(w, r) {
+		return
+	}
+
+	if p.GRPCWeb && isGRPCWebPreflight(r) {
+		serveGRPCWebPreflight(w, r)
+		return
+	}
+
+	p.serveProxy(w, r)
+}
+
 func (p *ProxyServer) modifyRequest(req *http.Request) {
-	target := *p.Target
+	ctx := getProxyContext(req)
+	route := ctx.route
+	target := *ctx.backend.target
+
+	if route.StripPrefix && route.PathPrefix != "" {
+		req.URL.Path = "/" + strings.TrimLeft(strings.TrimPrefix(req.URL.Path, strings.TrimRight(route.PathPrefix, "/")), "/")
+		req.URL.RawPath = ""
+	}
+
+	req.URL.Path, req.URL.RawPath = joinURLPath(&target, req.URL)
+
+	if target.RawQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = target.RawQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+	}
+
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// explicitly disable User-Agent so it's not set to default value
+		req.Header.Set("User-Agent", "")
+	}
+
 	isProxyUrl := req.URL.Query().Get("forward_url") != ""
 
 	if isProxyUrl {
@@ -170,6 +407,9 @@ func (p *ProxyServer) modifyRequest(req *http.Request) {
 		}
 	}
 
+	// the handshake of 'ws://' and 'wss://' is sent over HTTP
+	target.Scheme = httpScheme(target.Scheme)
+
 	req.Header.Set(headerXOriginHost, req.Host)
 	req.Host = target.Host
 	if isProxyUrl {
@@ -185,30 +425,81 @@ func (p *ProxyServer) modifyRequest(req *http.Request) {
 	req.Header.Set("Origin", fmt.Sprintf("%s://%s", target.Scheme, target.Host))
 	req.Header.Set("Referrer", fmt.Sprintf("%s://%s%s", target.Scheme, target.Host, req.URL.RawPath))
 	req.Header.Set("X-Real-IP", req.RemoteAddr)
+	req.Header.Del(headerXClientCertSubject)
 
-	for k := range p.ReqHeaders {
-		req.Header.Add(k, p.ReqHeaders.Get(k))
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(req.TLS.VerifiedChains[0]) > 0 {
+		req.Header.Set(headerXClientCertSubject, req.TLS.VerifiedChains[0][0].Subject.String())
 	}
-}
 
-func (p *ProxyServer) modifyContent(extNames []string, body []byte, originHost string, proxyHost string) []byte {
-	bodyStr := string(body)
+	addHeaders(req.Header, p.ReqHeaders, route.ReqHeaders)
 
-	bodyStr = replaceHost(bodyStr, originHost, proxyHost, p.UseSSL, p.ProxyExternal, p.ProxyExternalIgnores)
+	if e := getExchange(req); e != nil {
+		e.captureUpstreamRequest(req, p.maxCaptureBodySize)
+	}
+}
 
-	// https://developer.mozilla.org/zh-CN/docs/Web/Security/Subresource_Integrity
+// modifyContent rewrites the body from r to w as a stream.
+// flush is true if the length of body is unknown, the rewritten text is flushed once it is received.
+func (p *ProxyServer) modifyContent(w io.Writer, r io.Reader, extNames []string, originHost string, proxyHost string, pageURL *url.URL, isProxyUrl bool, flush bool) error {
 	if isHtml(extNames) {
-		bodyStr = regIntegrity.ReplaceAllString(bodyStr, "")
-
-		// <meta http-equiv="Content-Security-Policy" content="default-src 'self'; img-src https://*; child-src 'none';">
-		bodyStr = strings.ReplaceAll(bodyStr, `http-equiv="Content-Security-Policy"`, "") // disabled CSP
+		return rewriteHTML(w, r, &urlRewriter{
+			originHost:           originHost,
+			proxyHost:            proxyHost,
+			useSSL:               p.UseSSL,
+			proxyExternal:        p.ProxyExternal,
+			proxyExternalIgnores: p.ProxyExternalIgnores,
+			base:                 pageURL,
+			isProxyUrl:           isProxyUrl,
+			injectLiveReload:     p.liveReload != nil,
+		})
 	}
 
-	return []byte(bodyStr)
+	return rewriteTextStream(w, r, flush, func(s string) string {
+		return replaceHost(s, originHost, proxyHost, p.UseSSL, p.ProxyExternal, p.ProxyExternalIgnores)
+	})
 }
 
 func (p *ProxyServer) modifyResponse(res *http.Response) error {
-	target := *p.Target
+	if err := p.rewriteResponse(res); err != nil {
+		return err
+	}
+
+	if err := p.handleResponseMiddlewares(res); err != nil {
+		return err
+	}
+
+	if ctx := getProxyContext(res.Request); ctx.grpcWeb != nil {
+		ctx.grpcWeb.translateGRPCResponse(res)
+	}
+
+	if res.StatusCode == http.StatusSwitchingProtocols && isWebSocket(res.Request) {
+		p.wrapWebSocket(res)
+	}
+
+	return nil
+}
+
+// hasResponseBody reports whether the response has a body to rewrite
+func hasResponseBody(res *http.Response) bool {
+	switch {
+	case res.StatusCode < 200, res.StatusCode == http.StatusNoContent, res.StatusCode == http.StatusNotModified:
+		// including 101 Switching Protocols which body is the upgraded connection
+		return false
+	case res.Request != nil && res.Request.Method == http.MethodHead:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *ProxyServer) rewriteResponse(res *http.Response) error {
+	ctx := getProxyContext(res.Request)
+	route := ctx.route
+	target := *ctx.backend.target
+
+	if e := getExchange(res.Request); e != nil {
+		e.captureUpstreamResponse(res, p.maxCaptureBodySize)
+	}
 	isProxyUrl := res.Request.URL.Query().Get("forward_url") != ""
 
 	if isProxyUrl {
@@ -276,7 +567,11 @@ func (p *ProxyServer) modifyResponse(res *http.Response) error {
 		res.Header.Del("Set-Cookie")
 
 		for _, v := range cookies {
-			v.Domain = hostName
+			if route.CookieDomain != "" {
+				v.Domain = route.CookieDomain
+			} else {
+				v.Domain = hostName
+			}
 			if v.Secure && !p.UseSSL {
 				v.Secure = false
 			}
@@ -309,144 +604,81 @@ func (p *ProxyServer) modifyResponse(res *http.Response) error {
 		res.Header.Set("Access-Control-Allow-Credentials", "true")
 	}
 
-	for k := range p.ResHeaders {
-		res.Header.Add(k, p.ResHeaders.Get(k))
+	addHeaders(res.Header, p.ResHeaders, route.ResHeaders)
+
+	// the upgraded connection and the responses without body are never rewritten
+	if !hasResponseBody(res) {
+		return nil
+	}
+
+	// the streaming response is passed through, it can not be buffered
+	if isStreamingResponse(res) {
+		if p.RewriteEventStream && isEventStream(res) {
+			return streamRewrite(res, func(w io.Writer, r io.Reader) error {
+				return rewriteEventStream(w, r, func(s string) string {
+					return replaceHost(s, target.Host, proxyHost, p.UseSSL, p.ProxyExternal, p.ProxyExternalIgnores)
+				})
+			})
+		}
+
+		return nil
 	}
 
 	// replace HTML/css/javascript... content
 	{
 		contentType := res.Header.Get("Content-Type")
 
-		extNames, err := mime.ExtensionsByType(contentType)
+		extNames, _ := mime.ExtensionsByType(contentType)
 
-		if err != nil {
+		shouldReplaceContent := isShouldReplaceContent(extNames)
+		rules := p.matchReplaceRules(res, ctx.path)
+
+		if !shouldReplaceContent && len(rules) == 0 {
 			return nil
 		}
 
-		if !isShouldReplaceContent(extNames) {
+		// the large response is passed through as is, the length is unknown for the chunked response
+		if p.MaxRewriteSize > 0 && res.ContentLength > p.MaxRewriteSize {
 			return nil
 		}
 
-		encoding := res.Header.Get("Content-Encoding")
+		pageURL := res.Request.URL
+		// the chunked response may be a long poll, the client should not wait for the whole chunk
+		unknownLength := res.ContentLength < 0
 
 		// https://developer.mozilla.org/zh-CN/docs/Web/HTTP/Headers/Content-Encoding
-		switch encoding {
-		case "gzip":
-			reader, err := gzip.NewReader(res.Body)
-
-			if err != nil {
-				return errors.WithStack(err)
-			}
-
-			defer reader.Close()
-
-			body, err := ioutil.ReadAll(reader)
-
-			if err != nil {
-				return errors.WithStack(err)
-			}
-
-			newBody := p.modifyContent(extNames, body, target.Host, proxyHost)
-
-			var b bytes.Buffer
-			gz := gzip.NewWriter(&b)
-
-			if _, err := gz.Write(newBody); err != nil {
-				return errors.WithStack(err)
+		return streamRewrite(res, func(w io.Writer, r io.Reader) error {
+			if len(rules) == 0 {
+				return p.modifyContent(w, r, extNames, target.Host, proxyHost, pageURL, isProxyUrl, unknownLength)
 			}
 
-			if err := gz.Close(); err != nil {
-				return errors.WithStack(err)
-			}
-
-			bin := b.Bytes()
-			res.Header.Set("Content-Length", fmt.Sprint(len(bin)))
-			res.Body = io.NopCloser(bytes.NewReader(bin))
-
-		case "compress":
-			// Deprecated by most browsers
-		case "deflate":
-			reader, err := zlib.NewReader(res.Body)
-
-			if err != nil {
-				return errors.WithStack(err)
-			}
-
-			body, err := ioutil.ReadAll(reader)
-
-			defer reader.Close()
-
-			if err != nil {
-				return errors.WithStack(err)
-			}
-
-			newBody := p.modifyContent(extNames, body, target.Host, proxyHost)
-
+			// the replace rules are applied to the whole body
 			buf := &bytes.Buffer{}
 
-			w := zlib.NewWriter(buf)
-
-			if n, err := w.Write(newBody); err != nil {
-				return errors.WithStack(err)
-			} else if n < len(newBody) {
-				return fmt.Errorf("n too small: %d vs %d for %s", n, len(newBody), string(newBody))
-			}
-
-			if err := w.Close(); err != nil {
-				return errors.WithStack(err)
-			}
-
-			res.Header.Set("Content-Length", fmt.Sprint(buf.Len()))
-			res.Body = io.NopCloser(buf)
-		case "br":
-			reader := brotli.NewReader(res.Body)
-
-			body, err := ioutil.ReadAll(reader)
-
-			if err != nil {
-				return errors.WithStack(err)
-			}
-
-			newBody := p.modifyContent(extNames, body, target.Host, proxyHost)
-
-			buf := &bytes.Buffer{}
-			w := brotli.NewWriter(buf)
-			if n, err := w.Write(newBody); err != nil {
-				return errors.WithStack(err)
-			} else if n < len(newBody) {
-				return fmt.Errorf("n too small: %d vs %d for %s", n, len(newBody), string(newBody))
-			}
-
-			if err := w.Close(); err != nil {
+			if shouldReplaceContent {
+				if err := p.modifyContent(buf, r, extNames, target.Host, proxyHost, pageURL, isProxyUrl, false); err != nil {
+					return err
+				}
+			} else if _, err := io.Copy(buf, r); err != nil {
 				return errors.WithStack(err)
 			}
 
-			res.Header.Set("Content-Length", fmt.Sprint(buf.Len()))
-			res.Body = io.NopCloser(buf)
-		case "identity":
-			fallthrough
-		default:
-			// origin response data without compress
-
-			body, err := ioutil.ReadAll(res.Body)
-
-			if err != nil {
-				return errors.WithStack(err)
-			}
+			body := buf.Bytes()
 
-			defer res.Body.Close()
+			for _, rule := range rules {
+				newBody, err := rule.apply(body)
 
-			newBody := p.modifyContent(extNames, body, target.Host, proxyHost)
+				if err != nil {
+					log.Printf("failed to replace '%s' of '%s': %+v\n", rule.Find, res.Request.URL.String(), err)
+					continue
+				}
 
-			if err != nil {
-				return errors.WithStack(err)
+				body = newBody
 			}
 
-			res.Header.Set("Content-Length", fmt.Sprint(len(newBody)))
-			res.Body = io.NopCloser(bytes.NewReader(newBody))
-		}
+			_, err := w.Write(body)
 
+			return errors.WithStack(err)
+		})
 	}
-
-	return nil
 }
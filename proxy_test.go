@@ -0,0 +1,86 @@
+package forward
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProxyServer_routeHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Global-Got", r.Header.Get("X-Global"))
+		w.Header().Set("X-Route-Got", r.Header.Get("X-Route"))
+		w.Header().Set("X-Override-Got", r.Header.Get("X-Override"))
+	}))
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+
+	server := NewProxyServer(&ProxyServerOptions{
+		Target:     target,
+		ReqHeaders: http.Header{"X-Global": []string{"global"}, "X-Override": []string{"global"}},
+		ResHeaders: http.Header{"X-Global-Res": []string{"global"}, "X-Override-Res": []string{"global"}},
+		Routes: []*Route{
+			{
+				PathPrefix: "/api",
+				Target:     target,
+				ReqHeaders: http.Header{"X-Route": []string{"route"}, "X-Override": []string{"route"}},
+				ResHeaders: http.Header{"X-Route-Res": []string{"route"}, "X-Override-Res": []string{"route"}},
+			},
+		},
+	})
+	defer server.Close()
+
+	proxy := httptest.NewServer(http.HandlerFunc(server.Handler()))
+	defer proxy.Close()
+
+	tests := []struct {
+		name string
+		path string
+		want map[string]string
+	}{
+		{
+			name: "matched route",
+			path: "/api/user",
+			want: map[string]string{
+				"X-Global-Got":   "global",
+				"X-Route-Got":    "route",
+				"X-Override-Got": "route",
+				"X-Global-Res":   "global",
+				"X-Route-Res":    "route",
+				"X-Override-Res": "route",
+			},
+		},
+		{
+			name: "default route",
+			path: "/",
+			want: map[string]string{
+				"X-Global-Got":   "global",
+				"X-Route-Got":    "",
+				"X-Override-Got": "global",
+				"X-Global-Res":   "global",
+				"X-Route-Res":    "",
+				"X-Override-Res": "global",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := http.Get(proxy.URL + tt.path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			for k, v := range tt.want {
+				// the header overridden by route has a single value
+				if got := strings.Join(res.Header.Values(k), ","); got != v {
+					t.Errorf("%s = %s, want %s", k, got, v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package forward
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ReplayFallbackNotFound    = "404"         // respond 404 if the response is not recorded
+	ReplayFallbackPassthrough = "passthrough" // request the upstream if the response is not recorded
+	ReplayFallbackError       = "error"       // respond error if the response is not recorded
+)
+
+// recordedResponse is the content of a recorded file
+type recordedResponse struct {
+	Host     string      `json:"host"`
+	Method   string      `json:"method"`
+	Path     string      `json:"path"`
+	Query    string      `json:"query,omitempty"`
+	BodyHash string      `json:"bodyHash,omitempty"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+}
+
+// recordTransport stores the responses of upstream into a folder
+type recordTransport struct {
+	transport http.RoundTripper
+	dir       string
+	matchBody bool
+}
+
+// replayTransport serves the recorded responses without requesting upstream
+type replayTransport struct {
+	transport http.RoundTripper
+	dir       string
+	matchBody bool
+	fallback  string
+}
+
+// recordKey generates the key of request by upstream host, method, path, normalized query and optional body hash
+func recordKey(req *http.Request, matchBody bool) (*recordedResponse, string, error) {
+	r := &recordedResponse{
+		Host:   recordHost(req),
+		Method: req.Method,
+		Path:   req.URL.Path,
+		// the query is normalized because Encode() sorts by key
+		Query: req.URL.Query().Encode(),
+	}
+
+	if matchBody && req.Body != nil && req.Body != http.NoBody {
+		body, err := ioutil.ReadAll(req.Body)
+
+		if err != nil {
+			return nil, "", errors.WithStack(err)
+		}
+
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(body)
+		r.BodyHash = hex.EncodeToString(sum[:])
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{r.Host, r.Method, r.Path, r.Query, r.BodyHash}, "\n")))
+
+	return r, hex.EncodeToString(sum[:]) + ".json", nil
+}
+
+// recordHost returns the host of upstream which the request is sent to.
+// the backends of a pool share the host of the first one, so the response recorded from any backend is replayed.
+func recordHost(req *http.Request) string {
+	ctx, ok := req.Context().Value(proxyContextKey).(*proxyContext)
+
+	if !ok || ctx.backend == nil || ctx.backend.target.Host != req.URL.Host {
+		return req.URL.Host
+	}
+
+	return ctx.upstream.backends[0].target.Host
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	record, fileName, err := recordKey(req, t.matchBody)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.transport.RoundTrip(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the upgrade response and the streaming response can not be recorded
+	if res.StatusCode == http.StatusSwitchingProtocols || isStreamingResponse(res) {
+		return res, nil
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+
+	_ = res.Body.Close()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	record.Status = res.StatusCode
+	record.Header = res.Header.Clone()
+	record.Body = body
+
+	if err := writeRecord(filepath.Join(t.dir, fileName), record); err != nil {
+		log.Printf("failed to record '%s %s': %+v\n", req.Method, req.URL.String(), err)
+	}
+
+	return res, nil
+}
+
+func writeRecord(filePath string, record *recordedResponse) error {
+	b, err := json.MarshalIndent(record, "", "  ")
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// write to a temporary file then rename, so that the replay never reads a partial file
+	tmpFilePath := filePath + ".tmp"
+
+	if err := ioutil.WriteFile(tmpFilePath, b, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(os.Rename(tmpFilePath, filePath))
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, fileName, err := recordKey(req, t.matchBody)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(t.dir, fileName))
+
+	if err == nil {
+		record := &recordedResponse{}
+
+		if err := json.Unmarshal(b, record); err != nil {
+			return nil, errors.Wrapf(err, "invalid recorded file '%s'", fileName)
+		}
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", record.Status, http.StatusText(record.Status)),
+			StatusCode:    record.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        record.Header,
+			Body:          io.NopCloser(bytes.NewReader(record.Body)),
+			ContentLength: int64(len(record.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+
+	log.Printf("replay miss: [%s]: %s", req.Method, req.URL.String())
+
+	switch t.fallback {
+	case ReplayFallbackPassthrough:
+		return t.transport.RoundTrip(req)
+	case ReplayFallbackError:
+		return nil, errors.Errorf("the response of '%s %s' is not recorded", req.Method, req.URL.String())
+	default:
+		body := fmt.Sprintf("the response of '%s %s' is not recorded\n", req.Method, req.URL.String())
+
+		return &http.Response{
+			Status:        "404 Not Found",
+			StatusCode:    http.StatusNotFound,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+}
@@ -0,0 +1,224 @@
+package forward
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_recordKey(t *testing.T) {
+	key := func(method, target, body string, matchBody bool) string {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+
+		_, fileName, err := recordKey(req, matchBody)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return fileName
+	}
+
+	if key("GET", "/api?b=2&a=1", "", false) != key("GET", "/api?a=1&b=2", "", false) {
+		t.Errorf("the query should be normalized")
+	}
+
+	if key("GET", "/api", "", false) == key("POST", "/api", "", false) {
+		t.Errorf("the method should be a part of key")
+	}
+
+	if key("POST", "/api", "foo", false) != key("POST", "/api", "bar", false) {
+		t.Errorf("the body should be ignored if not match body")
+	}
+
+	if key("POST", "/api", "foo", true) == key("POST", "/api", "bar", true) {
+		t.Errorf("the body should be a part of key if match body")
+	}
+
+	if key("GET", "http://a.example.com/api", "", false) == key("GET", "http://b.example.com/api", "", false) {
+		t.Errorf("the upstream host should be a part of key")
+	}
+
+	// the backends of a pool share the key
+	pool := newUpstream(&UpstreamPool{Targets: []*url.URL{{Scheme: "http", Host: "a.example.com"}, {Scheme: "http", Host: "b.example.com"}}})
+	req := httptest.NewRequest("GET", "http://b.example.com/api", nil)
+	req = req.WithContext(context.WithValue(req.Context(), proxyContextKey, &proxyContext{upstream: pool, backend: pool.backends[1]}))
+
+	if _, fileName, _ := recordKey(req, false); fileName != key("GET", "http://a.example.com/api", "", false) {
+		t.Errorf("the backends of a pool should share the key")
+	}
+}
+
+func TestProxyServer_recordReplay(t *testing.T) {
+	var hits int32
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("X-Recorded", "true")
+		_, _ = w.Write([]byte("hello " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	live, _ := url.Parse(backend.URL)
+
+	// the unreachable upstream, eg. on CI
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	dead, _ := url.Parse(closed.URL)
+
+	dir := t.TempDir()
+
+	get := func(server *ProxyServer, path string) (*http.Response, string) {
+		proxy := httptest.NewServer(http.HandlerFunc(server.Handler()))
+		defer proxy.Close()
+
+		res, err := http.Get(proxy.URL + path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		return res, string(body)
+	}
+
+	recorder := NewProxyServer(&ProxyServerOptions{Target: live, RecordDir: dir})
+	defer recorder.Close()
+
+	if res, body := get(recorder, "/api?b=2&a=1"); res.StatusCode != http.StatusOK || body != "hello /api" {
+		t.Fatalf("record: status = %d, body = %s", res.StatusCode, body)
+	}
+
+	tests := []struct {
+		name       string
+		fallback   string
+		target     *url.URL
+		path       string
+		wantStatus int
+		wantBody   string
+		wantHits   int32
+	}{
+		{name: "recorded", fallback: "", target: live, path: "/api?a=1&b=2", wantStatus: http.StatusOK, wantBody: "hello /api"},
+		{name: "404", fallback: ReplayFallbackNotFound, target: dead, path: "/missing", wantStatus: http.StatusNotFound, wantBody: "/missing' is not recorded"},
+		{name: "passthrough", fallback: ReplayFallbackPassthrough, target: live, path: "/missing", wantStatus: http.StatusOK, wantBody: "hello /missing", wantHits: 1},
+		{name: "error", fallback: ReplayFallbackError, target: dead, path: "/missing", wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&hits, 0)
+
+			server := NewProxyServer(&ProxyServerOptions{
+				Pool: &UpstreamPool{
+					Targets:     []*url.URL{tt.target},
+					HealthCheck: &HealthCheck{Path: "/healthz", Interval: 10 * time.Millisecond},
+				},
+				ReplayDir:      dir,
+				ReplayFallback: tt.fallback,
+			})
+			defer server.Close()
+
+			// the ejected backend does not prevent replaying
+			server.upstreams[server.defaultRoute].backends[0].eject("test")
+
+			res, body := get(server, tt.path)
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+
+			if tt.name == "recorded" && res.Header.Get("X-Recorded") != "true" {
+				t.Errorf("the recorded header is not replayed")
+			}
+
+			if got := atomic.LoadInt32(&hits); got != tt.wantHits {
+				t.Errorf("upstream hits = %d, want %d", got, tt.wantHits)
+			}
+		})
+	}
+
+	t.Run("empty pool", func(t *testing.T) {
+		server := NewProxyServer(&ProxyServerOptions{
+			Pool:      &UpstreamPool{HealthCheck: &HealthCheck{Path: "/healthz"}},
+			ReplayDir: dir,
+		})
+		defer server.Close()
+
+		if res, _ := get(server, "/api?a=1&b=2"); res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want %d", res.StatusCode, http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func TestProxyServer_recordHosts(t *testing.T) {
+	newBackend := func(name string) (*httptest.Server, *url.URL) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name + " " + r.URL.Path))
+		}))
+
+		target, _ := url.Parse(backend.URL)
+
+		return backend, target
+	}
+
+	a, targetA := newBackend("a")
+	defer a.Close()
+
+	b, targetB := newBackend("b")
+	defer b.Close()
+
+	dir := t.TempDir()
+
+	get := func(options *ProxyServerOptions, host string) string {
+		options.Target = targetB
+		options.Routes = []*Route{{Host: "a.test", Target: targetA}}
+
+		server := NewProxyServer(options)
+		defer server.Close()
+
+		proxy := httptest.NewServer(http.HandlerFunc(server.Handler()))
+		defer proxy.Close()
+
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/api", nil)
+		req.Host = host
+
+		res, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		return string(body)
+	}
+
+	for _, host := range []string{"a.test", "b.test"} {
+		get(&ProxyServerOptions{RecordDir: dir}, host)
+	}
+
+	// the upstreams are not requested in replay mode
+	a.Close()
+	b.Close()
+
+	if got := get(&ProxyServerOptions{ReplayDir: dir}, "a.test"); got != "a /api" {
+		t.Errorf("replay a.test = %s, want 'a /api'", got)
+	}
+
+	if got := get(&ProxyServerOptions{ReplayDir: dir}, "b.test"); got != "b /api" {
+		t.Errorf("replay b.test = %s, want 'b /api'", got)
+	}
+}
@@ -0,0 +1,474 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ReplaceLiteral  = "literal"  // replace the text as is
+	ReplaceRegex    = "regex"    // replace the matches of regular expression, '$1' in replacement refers to the submatch
+	ReplaceJSONPath = "jsonpath" // replace the values selected by JSONPath with the JSON replacement, XPath is not supported
+)
+
+// ReplaceRule substitutes the content of response body after the urls are rewritten.
+// The empty scopes match any response, except that the empty content type matches the text responses only.
+type ReplaceRule struct {
+	Type        string // 'literal', 'regex' or 'jsonpath'. defaults to 'literal'
+	Find        string // the text, regular expression or JSONPath, eg. '$.features.banner'
+	Replace     string // the replacement. it is a JSON value for 'jsonpath', and used as a string if it is not valid JSON
+	ContentType string // match the media type of response, eg. 'application/json' or 'text/*'. defaults to the text types, eg. HTML, CSS, JavaScript, JSON and XML
+	Path        string // match the path of request with glob, eg. '/api/*/config'
+	Status      int    // match the status code of response
+
+	regexp   *regexp.Regexp
+	jsonPath []jsonPathSegment
+	value    interface{} // the decoded replacement, it is copied for each replacing so it is never modified
+	compiled bool
+}
+
+// Compile parses the regular expression or JSONPath of rule
+func (r *ReplaceRule) Compile() error {
+	if r.compiled {
+		return nil
+	}
+
+	if r.Find == "" {
+		return errors.New("the find of replace rule can not be empty")
+	}
+
+	switch r.Type {
+	case "", ReplaceLiteral:
+	case ReplaceRegex:
+		reg, err := regexp.Compile(r.Find)
+
+		if err != nil {
+			return errors.Wrapf(err, "invalid regular expression '%s'", r.Find)
+		}
+
+		r.regexp = reg
+	case ReplaceJSONPath:
+		segments, err := parseJSONPath(r.Find)
+
+		if err != nil {
+			return err
+		}
+
+		r.jsonPath = segments
+
+		value, err := decodeJSON([]byte(r.Replace))
+
+		if err != nil {
+			value = r.Replace
+		}
+
+		r.value = value
+	default:
+		return errors.Errorf("invalid replace type '%s', it must be '%s', '%s' or '%s'", r.Type, ReplaceLiteral, ReplaceRegex, ReplaceJSONPath)
+	}
+
+	if r.Path != "" {
+		if _, err := path.Match(r.Path, "/"); err != nil {
+			return errors.Wrapf(err, "invalid path glob '%s'", r.Path)
+		}
+	}
+
+	r.compiled = true
+
+	return nil
+}
+
+func (r *ReplaceRule) match(res *http.Response, requestPath string) bool {
+	if r.Status != 0 && r.Status != res.StatusCode {
+		return false
+	}
+
+	if r.Path != "" {
+		if ok, _ := path.Match(r.Path, requestPath); !ok {
+			return false
+		}
+	}
+
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+
+	if err != nil {
+		return false
+	}
+
+	mediaType = strings.ToLower(mediaType)
+
+	// the binary body is not replaced unless the rule asks for it explicitly
+	if r.ContentType == "" {
+		return isTextMediaType(mediaType)
+	}
+
+	return matchMediaType(r.ContentType, mediaType)
+}
+
+// the media types of text except 'text/*'
+var textMediaTypes = map[string]struct{}{
+	"application/json":                  {},
+	"application/javascript":            {},
+	"application/x-javascript":          {},
+	"application/ecmascript":            {},
+	"application/xml":                   {},
+	"application/x-www-form-urlencoded": {},
+}
+
+// isTextMediaType reports whether the media type is text, eg. 'text/html', 'application/json' or 'image/svg+xml'
+func isTextMediaType(mediaType string) bool {
+	if _, ok := textMediaTypes[mediaType]; ok {
+		return true
+	}
+
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+func (r *ReplaceRule) apply(body []byte) ([]byte, error) {
+	switch r.Type {
+	case ReplaceRegex:
+		return r.regexp.ReplaceAll(body, []byte(r.Replace)), nil
+	case ReplaceJSONPath:
+		data, err := decodeJSON(body)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "the body is not a valid JSON")
+		}
+
+		if !setJSONPath(&data, r.jsonPath, r.value) {
+			return body, nil
+		}
+
+		// the JSON is encoded compactly
+		var buf bytes.Buffer
+
+		if err := encodeJSON(&buf, data); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	default:
+		return bytes.ReplaceAll(body, []byte(r.Find), []byte(r.Replace)), nil
+	}
+}
+
+// matchMediaType matches the media type with pattern, eg. 'text/*'
+func matchMediaType(pattern string, mediaType string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return pattern == mediaType
+}
+
+// matchReplaceRules returns the rules which match the response
+func (p *ProxyServer) matchReplaceRules(res *http.Response, requestPath string) []*ReplaceRule {
+	var rules []*ReplaceRule
+
+	for _, rule := range p.ReplaceRules {
+		if rule.compiled && rule.match(res, requestPath) {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
+
+// jsonPathSegment is a key, an index or a wildcard of JSONPath
+type jsonPathSegment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseJSONPath parses the subset of JSONPath: '$.a.b', "$['a']", '$.a[0]', '$.a[*].b' and '$.*'
+func parseJSONPath(s string) ([]jsonPathSegment, error) {
+	invalid := func() ([]jsonPathSegment, error) {
+		return nil, errors.Errorf("invalid JSONPath '%s'", s)
+	}
+
+	if !strings.HasPrefix(s, "$") {
+		return invalid()
+	}
+
+	var segments []jsonPathSegment
+
+	rest := s[1:]
+
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+
+			if end < 0 {
+				end = len(rest)
+			}
+
+			key := rest[:end]
+			rest = rest[end:]
+
+			if key == "" {
+				return invalid()
+			}
+
+			segments = append(segments, jsonPathSegment{key: key, wildcard: key == "*"})
+		case '[':
+			end := strings.Index(rest, "]")
+
+			if end < 0 {
+				return invalid()
+			}
+
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+
+			switch {
+			case inner == "*":
+				segments = append(segments, jsonPathSegment{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				segments = append(segments, jsonPathSegment{key: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+
+				if err != nil {
+					return invalid()
+				}
+
+				segments = append(segments, jsonPathSegment{index: index, isIndex: true})
+			}
+		default:
+			return invalid()
+		}
+	}
+
+	if len(segments) == 0 {
+		return invalid()
+	}
+
+	return segments, nil
+}
+
+// setJSONPath sets the value at the path, returns false if nothing is selected
+func setJSONPath(data *interface{}, segments []jsonPathSegment, value interface{}) bool {
+	if len(segments) == 0 {
+		// the value is shared by the concurrent requests and the selected values
+		*data = copyJSON(value)
+		return true
+	}
+
+	segment, rest := segments[0], segments[1:]
+	changed := false
+
+	switch v := (*data).(type) {
+	case *jsonObject:
+		if segment.isIndex {
+			return false
+		}
+
+		for _, k := range v.keys {
+			if segment.wildcard || k == segment.key {
+				child := v.values[k]
+
+				if setJSONPath(&child, rest, value) {
+					v.values[k] = child
+					changed = true
+				}
+			}
+		}
+	case []interface{}:
+		if segment.key != "" && !segment.wildcard {
+			return false
+		}
+
+		for i := range v {
+			index := segment.index
+
+			// the negative index counts from the end
+			if index < 0 {
+				index += len(v)
+			}
+
+			if segment.wildcard || i == index {
+				if setJSONPath(&v[i], rest, value) {
+					changed = true
+				}
+			}
+		}
+	}
+
+	return changed
+}
+
+// copyJSON returns a deep copy of the decoded JSON value
+func copyJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *jsonObject:
+		o := &jsonObject{
+			keys:   append([]string(nil), v.keys...),
+			values: make(map[string]interface{}, len(v.values)),
+		}
+
+		for k, child := range v.values {
+			o.values[k] = copyJSON(child)
+		}
+
+		return o
+	case []interface{}:
+		a := make([]interface{}, len(v))
+
+		for i, child := range v {
+			a[i] = copyJSON(child)
+		}
+
+		return a
+	default:
+		// the strings, numbers, booleans and null are immutable
+		return v
+	}
+}
+
+// jsonObject is a JSON object which keeps the order of keys
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// decodeJSON decodes the JSON value, the objects are decoded as *jsonObject and the numbers as json.Number
+func decodeJSON(b []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	v, err := decodeJSONValue(decoder)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid character after the JSON value")
+	}
+
+	return v, nil
+}
+
+func decodeJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		object := &jsonObject{values: map[string]interface{}{}}
+
+		for decoder.More() {
+			key, err := decoder.Token()
+
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := decodeJSONValue(decoder)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := object.values[key.(string)]; !ok {
+				object.keys = append(object.keys, key.(string))
+			}
+
+			object.values[key.(string)] = value
+		}
+
+		_, err = decoder.Token()
+
+		return object, err
+	case json.Delim('['):
+		array := []interface{}{}
+
+		for decoder.More() {
+			value, err := decodeJSONValue(decoder)
+
+			if err != nil {
+				return nil, err
+			}
+
+			array = append(array, value)
+		}
+
+		_, err = decoder.Token()
+
+		return array, err
+	default:
+		return token, nil
+	}
+}
+
+// encodeJSON encodes the value decoded by decodeJSON, '<', '>' and '&' are not escaped
+func encodeJSON(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case *jsonObject:
+		buf.WriteByte('{')
+
+		for i, k := range v.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			if err := encodeJSON(buf, k); err != nil {
+				return err
+			}
+
+			buf.WriteByte(':')
+
+			if err := encodeJSON(buf, v.values[k]); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			if err := encodeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteByte(']')
+	default:
+		var b bytes.Buffer
+
+		encoder := json.NewEncoder(&b)
+		encoder.SetEscapeHTML(false)
+
+		if err := encoder.Encode(v); err != nil {
+			return errors.WithStack(err)
+		}
+
+		// the encoder appends a newline
+		buf.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+	}
+
+	return nil
+}
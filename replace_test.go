@@ -0,0 +1,166 @@
+package forward
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestReplaceRule_apply(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ReplaceRule
+		body string
+		want string
+	}{
+		{
+			name: "literal",
+			rule: ReplaceRule{Find: "Hello", Replace: "Hi"},
+			body: "Hello World, Hello",
+			want: "Hi World, Hi",
+		},
+		{
+			name: "regex",
+			rule: ReplaceRule{Type: ReplaceRegex, Find: `v(\d+)\.(\d+)`, Replace: "v$1.$2-dev"},
+			body: "version: v1.2",
+			want: "version: v1.2-dev",
+		},
+		{
+			name: "jsonpath",
+			rule: ReplaceRule{Type: ReplaceJSONPath, Find: "$.features.banner", Replace: "false"},
+			body: `{"features":{"banner":true,"beta":true},"id":12345678901234567890}`,
+			want: `{"features":{"banner":false,"beta":true},"id":12345678901234567890}`,
+		},
+		{
+			name: "jsonpath with string",
+			rule: ReplaceRule{Type: ReplaceJSONPath, Find: "$.items[*].name", Replace: "hidden"},
+			body: `{"items":[{"name":"a"},{"name":"b"}]}`,
+			want: `{"items":[{"name":"hidden"},{"name":"hidden"}]}`,
+		},
+		{
+			name: "jsonpath with index",
+			rule: ReplaceRule{Type: ReplaceJSONPath, Find: "$['items'][-1]", Replace: `{"name":"c"}`},
+			body: `{"items":[{"name":"a"},{"name":"b"}]}`,
+			want: `{"items":[{"name":"a"},{"name":"c"}]}`,
+		},
+		{
+			name: "jsonpath keeps the order of keys and the html characters",
+			rule: ReplaceRule{Type: ReplaceJSONPath, Find: "$.z.enabled", Replace: `{"y":"<b>","x":1}`},
+			body: `{"z":{"enabled":false,"a":1},"html":"<a href='/?a=1&b=2'>"}`,
+			want: `{"z":{"enabled":{"y":"<b>","x":1},"a":1},"html":"<a href='/?a=1&b=2'>"}`,
+		},
+		{
+			name: "jsonpath not found",
+			rule: ReplaceRule{Type: ReplaceJSONPath, Find: "$.foo.bar", Replace: "1"},
+			body: `{ "foo": 1 }`,
+			want: `{ "foo": 1 }`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.Compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := tt.rule.apply([]byte(tt.body))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("apply() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceRule_match(t *testing.T) {
+	html := "text/html; charset=utf-8"
+
+	tests := []struct {
+		name        string
+		rule        ReplaceRule
+		contentType string
+		want        bool
+	}{
+		{name: "any", rule: ReplaceRule{}, contentType: html, want: true},
+		{name: "content type", rule: ReplaceRule{ContentType: "text/html"}, contentType: html, want: true},
+		{name: "content type wildcard", rule: ReplaceRule{ContentType: "text/*"}, contentType: html, want: true},
+		{name: "other content type", rule: ReplaceRule{ContentType: "application/json"}, contentType: html, want: false},
+		{name: "path", rule: ReplaceRule{Path: "/api/*/config"}, contentType: html, want: true},
+		{name: "other path", rule: ReplaceRule{Path: "/static/*"}, contentType: html, want: false},
+		{name: "status", rule: ReplaceRule{Status: 200}, contentType: html, want: true},
+		{name: "other status", rule: ReplaceRule{Status: 404}, contentType: html, want: false},
+		{name: "binary", rule: ReplaceRule{}, contentType: "image/png", want: false},
+		{name: "binary content type", rule: ReplaceRule{ContentType: "image/*"}, contentType: "image/png", want: true},
+		{name: "json", rule: ReplaceRule{}, contentType: "application/problem+json", want: true},
+		{name: "no content type", rule: ReplaceRule{}, contentType: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{tt.contentType}},
+			}
+
+			if got := tt.rule.match(res, "/api/v1/config"); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceRule_applyConcurrently(t *testing.T) {
+	insert := &ReplaceRule{Type: ReplaceJSONPath, Find: "$.items[*]", Replace: `{"a":{"b":1}}`}
+	edit := &ReplaceRule{Type: ReplaceJSONPath, Find: "$.items[0].a.b", Replace: "2"}
+
+	for _, rule := range []*ReplaceRule{insert, edit} {
+		if err := rule.Compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := `{"items":[{"a":{"b":2}},{"a":{"b":1}}]}`
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			body, err := insert.apply([]byte(`{"items":[0,1]}`))
+
+			if err == nil {
+				body, err = edit.apply(body)
+			}
+
+			if err != nil || string(body) != want {
+				t.Errorf("apply() = %s, %v, want %s", body, err, want)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// the later rule edits the inserted value in place
+	data, _ := decodeJSON([]byte(`{"items":[0,1]}`))
+
+	setJSONPath(&data, insert.jsonPath, insert.value)
+	setJSONPath(&data, edit.jsonPath, edit.value)
+
+	var buf bytes.Buffer
+
+	if err := encodeJSON(&buf, data); err != nil || buf.String() != want {
+		t.Errorf("setJSONPath() = %s, %v, want %s", buf.String(), err, want)
+	}
+
+	buf.Reset()
+
+	if err := encodeJSON(&buf, insert.value); err != nil || buf.String() != `{"a":{"b":1}}` {
+		t.Errorf("the value of rule is modified: %s", buf.String())
+	}
+}
@@ -0,0 +1,391 @@
+package forward
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+)
+
+const (
+	// the max steps of a hook, to avoid the infinite loop blocks the request
+	maxScriptExecutionSteps  = 100000000
+	defaultScriptMaxBodySize = 10 * 1024 * 1024
+)
+
+// Script runs the 'onRequest' and 'onResponse' hooks defined in a Starlark file.
+// see https://github.com/bazelbuild/starlark/blob/master/spec.md
+//
+// the hooks receive a dict of the request or response, and change it in place:
+//
+//	def onRequest(req):
+//	    # req: method, url, path, headers, body
+//	    # return a dict with status, headers and body to respond without proxying
+//
+//	def onResponse(res):
+//	    # res: method, url, status, headers, body
+//
+// the script is sandboxed, it can not access the file system or network.
+// the hooks are not called for the streams, eg. gRPC, and the bodies larger than MaxBodySize, they are passed through untouched.
+type Script struct {
+	MaxBodySize int64 // the max size of body passed to the hooks, defaults to 10MB
+
+	fileName   string
+	onRequest  starlark.Callable
+	onResponse starlark.Callable
+}
+
+// NewScript loads the script file
+func NewScript(filePath string) (*Script, error) {
+	src, err := ioutil.ReadFile(filePath)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	s := &Script{fileName: filePath}
+
+	globals, err := starlark.ExecFile(s.newThread(), filePath, src, starlark.StringDict{
+		"json": json.Module,
+	})
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid script '%s'", filePath)
+	}
+
+	for name, hook := range map[string]*starlark.Callable{"onRequest": &s.onRequest, "onResponse": &s.onResponse} {
+		v, ok := globals[name]
+
+		if !ok {
+			continue
+		}
+
+		fn, ok := v.(starlark.Callable)
+
+		if !ok {
+			return nil, errors.Errorf("invalid script '%s': '%s' must be a function", filePath, name)
+		}
+
+		*hook = fn
+	}
+
+	if s.onRequest == nil && s.onResponse == nil {
+		return nil, errors.Errorf("invalid script '%s': neither 'onRequest' nor 'onResponse' is defined", filePath)
+	}
+
+	return s, nil
+}
+
+func (s *Script) newThread() *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: s.fileName,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Printf("[%s] %s\n", s.fileName, msg)
+		},
+	}
+
+	thread.SetMaxExecutionSteps(maxScriptExecutionSteps)
+
+	return thread
+}
+
+func (s *Script) call(hook starlark.Callable, arg *starlark.Dict) (starlark.Value, error) {
+	v, err := starlark.Call(s.newThread(), hook, starlark.Tuple{arg}, nil)
+
+	if err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			return nil, errors.Errorf("script error: %s", evalErr.Backtrace())
+		}
+
+		return nil, errors.Wrap(err, "script error")
+	}
+
+	return v, nil
+}
+
+func (s *Script) HandleRequest(req *http.Request) (*http.Response, error) {
+	if s.onRequest == nil || isStreamingContentType(req.Header.Get("Content-Type")) {
+		return nil, nil
+	}
+
+	body, ok, err := readBody(&req.Body, s.maxBodySize())
+
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	dict := starlark.NewDict(5)
+	_ = dict.SetKey(starlark.String("method"), starlark.String(req.Method))
+	_ = dict.SetKey(starlark.String("url"), starlark.String(req.URL.String()))
+	_ = dict.SetKey(starlark.String("path"), starlark.String(req.URL.Path))
+	_ = dict.SetKey(starlark.String("headers"), headerToDict(req.Header))
+	_ = dict.SetKey(starlark.String("body"), starlark.String(body))
+
+	v, err := s.call(s.onRequest, dict)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if method, err := dictString(dict, "method"); err != nil {
+		return nil, err
+	} else if method != "" {
+		req.Method = method
+	}
+
+	if rawURL, err := dictString(dict, "url"); err != nil {
+		return nil, err
+	} else if rawURL != req.URL.String() {
+		u, err := url.Parse(rawURL)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "script error: invalid url '%s'", rawURL)
+		}
+
+		req.URL = u
+	} else if path, err := dictString(dict, "path"); err != nil {
+		return nil, err
+	} else if path != req.URL.Path {
+		req.URL.Path = path
+		req.URL.RawPath = ""
+	}
+
+	if err := updateHeader(req.Header, dict); err != nil {
+		return nil, err
+	}
+
+	newBody, err := dictString(dict, "body")
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Body = ioutil.NopCloser(strings.NewReader(newBody))
+	req.ContentLength = int64(len(newBody))
+	req.Header.Del("Content-Length")
+
+	if newBody == "" {
+		req.Body = http.NoBody
+	}
+
+	// the hook responds without proxying
+	if res, ok := v.(*starlark.Dict); ok {
+		return dictToResponse(res, req)
+	}
+
+	return nil, nil
+}
+
+func (s *Script) HandleResponse(res *http.Response) (*http.Response, error) {
+	// the stream can not be read as a whole
+	if s.onResponse == nil || isStreamingResponse(res) {
+		return nil, nil
+	}
+
+	body, ok, err := readBody(&res.Body, s.maxBodySize())
+
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	dict := starlark.NewDict(5)
+	_ = dict.SetKey(starlark.String("method"), starlark.String(res.Request.Method))
+	_ = dict.SetKey(starlark.String("url"), starlark.String(res.Request.URL.String()))
+	_ = dict.SetKey(starlark.String("status"), starlark.MakeInt(res.StatusCode))
+	_ = dict.SetKey(starlark.String("headers"), headerToDict(res.Header))
+	_ = dict.SetKey(starlark.String("body"), starlark.String(body))
+
+	if _, err := s.call(s.onResponse, dict); err != nil {
+		return nil, err
+	}
+
+	if status, err := dictInt(dict, "status"); err != nil {
+		return nil, err
+	} else if status > 0 {
+		res.StatusCode = status
+		res.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	}
+
+	if err := updateHeader(res.Header, dict); err != nil {
+		return nil, err
+	}
+
+	newBody, err := dictString(dict, "body")
+
+	if err != nil {
+		return nil, err
+	}
+
+	res.Body = ioutil.NopCloser(strings.NewReader(newBody))
+	res.ContentLength = int64(len(newBody))
+	res.Header.Del("Content-Length")
+
+	return nil, nil
+}
+
+func (s *Script) maxBodySize() int64 {
+	if s.MaxBodySize > 0 {
+		return s.MaxBodySize
+	}
+
+	return defaultScriptMaxBodySize
+}
+
+// readBody reads the whole body and replaces it with a new reader.
+// it returns false if the body is larger than limit, the body is kept as is.
+func readBody(body *io.ReadCloser, limit int64) (string, bool, error) {
+	if *body == nil || *body == http.NoBody {
+		return "", true, nil
+	}
+
+	// read one more byte to know whether the body exceeds the limit
+	b, err := ioutil.ReadAll(io.LimitReader(*body, limit+1))
+
+	if err != nil {
+		_ = (*body).Close()
+		return "", false, errors.WithStack(err)
+	}
+
+	if int64(len(b)) > limit {
+		// read the buffered bytes then the rest of body
+		*body = &readCloser{Reader: io.MultiReader(bytes.NewReader(b), *body), Closer: *body}
+		return "", false, nil
+	}
+
+	_ = (*body).Close()
+
+	*body = ioutil.NopCloser(bytes.NewReader(b))
+
+	return string(b), true, nil
+}
+
+// headerToDict converts the header to dict, only the first value of header is kept
+func headerToDict(header http.Header) *starlark.Dict {
+	dict := starlark.NewDict(len(header))
+
+	for k := range header {
+		_ = dict.SetKey(starlark.String(k), starlark.String(header.Get(k)))
+	}
+
+	return dict
+}
+
+// updateHeader applies the changes of 'headers' in dict to the header
+func updateHeader(header http.Header, dict *starlark.Dict) error {
+	v, found, err := dict.Get(starlark.String("headers"))
+
+	if err != nil || !found {
+		return errors.WithStack(err)
+	}
+
+	headers, ok := v.(*starlark.Dict)
+
+	if !ok {
+		return errors.Errorf("script error: 'headers' must be a dict, got %s", v.Type())
+	}
+
+	values := map[string]string{}
+
+	for _, item := range headers.Items() {
+		k, ok1 := starlark.AsString(item[0])
+		v, ok2 := starlark.AsString(item[1])
+
+		if !ok1 || !ok2 {
+			return errors.Errorf("script error: the key and value of 'headers' must be string, got %s: %s", item[0].Type(), item[1].Type())
+		}
+
+		values[http.CanonicalHeaderKey(k)] = v
+	}
+
+	for k := range header {
+		if _, ok := values[k]; !ok {
+			header.Del(k)
+		}
+	}
+
+	for k, v := range values {
+		// keep the multiple values if the header is not changed
+		if header.Get(k) != v {
+			header.Set(k, v)
+		}
+	}
+
+	return nil
+}
+
+func dictString(dict *starlark.Dict, key string) (string, error) {
+	v, found, _ := dict.Get(starlark.String(key))
+
+	if !found || v == starlark.None {
+		return "", nil
+	}
+
+	s, ok := starlark.AsString(v)
+
+	if !ok {
+		return "", errors.Errorf("script error: '%s' must be a string, got %s", key, v.Type())
+	}
+
+	return s, nil
+}
+
+func dictInt(dict *starlark.Dict, key string) (int, error) {
+	v, found, _ := dict.Get(starlark.String(key))
+
+	if !found || v == starlark.None {
+		return 0, nil
+	}
+
+	var i int
+
+	if err := starlark.AsInt(v, &i); err != nil {
+		return 0, errors.Errorf("script error: '%s' must be an int, got %s", key, v.Type())
+	}
+
+	return i, nil
+}
+
+// dictToResponse converts the dict returned by 'onRequest' to response
+func dictToResponse(dict *starlark.Dict, req *http.Request) (*http.Response, error) {
+	status, err := dictInt(dict, "status")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	body, err := dictString(dict, "body")
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+
+	if err := updateHeader(res.Header, dict); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,163 @@
+package forward
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScript(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "rules.star")
+
+	err := ioutil.WriteFile(filePath, []byte(`
+def onRequest(req):
+    if req["path"].startswith("/api"):
+        req["headers"]["Authorization"] = "Bearer token"
+    if req["path"] == "/mock":
+        return {"status": 201, "headers": {"Content-Type": "text/plain"}, "body": "mocked"}
+
+def onResponse(res):
+    if res["headers"].get("Content-Type") == "application/json":
+        data = json.decode(res["body"])
+        data["enabled"] = True
+        res["body"] = json.encode(data)
+        res["headers"].pop("X-Remove")
+`), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := NewScript(filePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user", nil)
+
+	if res, err := script.HandleRequest(req); err != nil || res != nil {
+		t.Fatalf("HandleRequest() = %v, %v", res, err)
+	}
+
+	if got := req.Header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization = %s, want %s", got, "Bearer token")
+	}
+
+	res, err := script.HandleRequest(httptest.NewRequest(http.MethodGet, "/mock", nil))
+
+	if err != nil || res == nil {
+		t.Fatalf("HandleRequest() = %v, %v", res, err)
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+
+	if res.StatusCode != 201 || string(body) != "mocked" || res.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("HandleRequest() = %d %s %v", res.StatusCode, body, res.Header)
+	}
+
+	res = &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}, "X-Remove": []string{"1"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"name":"forward"}`)),
+		Request:    req,
+	}
+
+	if _, err := script.HandleResponse(res); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ = ioutil.ReadAll(res.Body)
+
+	if string(body) != `{"enabled":true,"name":"forward"}` {
+		t.Errorf("HandleResponse() body = %s", body)
+	}
+
+	if res.Header.Get("X-Remove") != "" {
+		t.Errorf("the header removed by script should be deleted")
+	}
+
+	if err := ioutil.WriteFile(filePath, []byte("def onRequest(req):\n    return 1 // 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	script, err = NewScript(filePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := script.HandleRequest(httptest.NewRequest(http.MethodGet, "/", nil)); err == nil || !strings.Contains(err.Error(), "script error") {
+		t.Errorf("HandleRequest() error = %v, want script error", err)
+	}
+}
+
+func TestScript_passThrough(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "rules.star")
+
+	err := ioutil.WriteFile(filePath, []byte(`
+def onRequest(req):
+    req["body"] = "changed"
+
+def onResponse(res):
+    res["body"] = "changed"
+`), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := NewScript(filePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script.MaxBodySize = 4
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{name: "small body", contentType: "application/json", body: "1234", want: "changed"},
+		{name: "large body", contentType: "application/json", body: "12345", want: "12345"},
+		{name: "grpc", contentType: "application/grpc", body: "1", want: "1"},
+		{name: "ndjson", contentType: "application/x-ndjson", body: "1", want: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			// the length of chunked body is unknown
+			req.ContentLength = -1
+
+			if _, err := script.HandleRequest(req); err != nil {
+				t.Fatal(err)
+			}
+
+			if body, _ := ioutil.ReadAll(req.Body); string(body) != tt.want {
+				t.Errorf("request body = %s, want %s", body, tt.want)
+			}
+
+			res := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{tt.contentType}},
+				Body:       ioutil.NopCloser(strings.NewReader(tt.body)),
+				Request:    req,
+			}
+
+			if _, err := script.HandleResponse(res); err != nil {
+				t.Fatal(err)
+			}
+
+			if body, _ := ioutil.ReadAll(res.Body); string(body) != tt.want {
+				t.Errorf("response body = %s, want %s", body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+)
+
+const (
+	// the size of text to rewrite at once
+	rewriteChunkSize = 32 * 1024
+	// the chunk is flushed anyway if no boundary found in so many bytes
+	maxRewriteLookahead = 256 * 1024
+)
+
+// the bytes which can not be a part of url, it is safe to split the text after them
+var urlBoundaryBytes = " \t\r\n\"'`<>"
+
+// the content types of the responses which are consumed while receiving, they can not be buffered
+var streamingContentTypes = map[string]struct{}{
+	"text/event-stream":         {},
+	"application/x-ndjson":      {},
+	"application/stream+json":   {},
+	"multipart/x-mixed-replace": {},
+}
+
+// isStreamingResponse reports whether the response is a stream, eg. Server-Sent Events
+func isStreamingResponse(res *http.Response) bool {
+	return isStreamingContentType(res.Header.Get("Content-Type"))
+}
+
+// isStreamingContentType reports whether the body of content type is a stream, it applies to the requests as well
+func isStreamingContentType(contentType string) bool {
+	contentType = mediaType(contentType)
+
+	if _, ok := streamingContentTypes[contentType]; ok {
+		return true
+	}
+
+	// the gRPC messages are streamed in both directions
+	return isGRPC(contentType) || isGRPCWebContentType(contentType)
+}
+
+func isEventStream(res *http.Response) bool {
+	return mediaType(res.Header.Get("Content-Type")) == "text/event-stream"
+}
+
+// mediaType returns the lower case media type without parameters
+func mediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(mediaType)
+}
+
+// decodeBody returns a reader of the decoded body, returns false if the content encoding is not supported
+func decodeBody(r io.Reader, encoding string) (io.Reader, bool, error) {
+	switch encoding {
+	case "gzip":
+		reader, err := gzip.NewReader(r)
+
+		if err != nil {
+			return nil, true, errors.WithStack(err)
+		}
+
+		return reader, true, nil
+	case "deflate":
+		reader, err := zlib.NewReader(r)
+
+		if err != nil {
+			return nil, true, errors.WithStack(err)
+		}
+
+		return reader, true, nil
+	case "br":
+		return brotli.NewReader(r), true, nil
+	case "", "identity":
+		return r, true, nil
+	default:
+		// eg. 'compress' which is deprecated by most browsers
+		return nil, false, nil
+	}
+}
+
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type nopFlushWriteCloser struct {
+	io.Writer
+}
+
+func (nopFlushWriteCloser) Flush() error { return nil }
+func (nopFlushWriteCloser) Close() error { return nil }
+
+// encodeBody returns a writer which encodes the body with the content encoding
+func encodeBody(w io.Writer, encoding string) flushWriteCloser {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w)
+	case "deflate":
+		return zlib.NewWriter(w)
+	case "br":
+		return brotli.NewWriter(w)
+	default:
+		return nopFlushWriteCloser{w}
+	}
+}
+
+// flushWriter flushes the encoder after each write, so that the client receives the rewritten chunk immediately.
+// it is used behind a bufio.Writer, so the encoder is not flushed for each small write.
+type flushWriter struct {
+	w flushWriteCloser
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+
+	if err != nil {
+		return n, err
+	}
+
+	return n, f.w.Flush()
+}
+
+type pipeBody struct {
+	*io.PipeReader
+	origin io.Closer
+}
+
+func (b *pipeBody) Close() error {
+	_ = b.PipeReader.Close()
+
+	return b.origin.Close()
+}
+
+// streamRewrite replaces the body of response with a stream: decode -> rewrite -> encode.
+// the rewriting runs in the background while the proxy copies the body to client.
+func streamRewrite(res *http.Response, rewrite func(w io.Writer, r io.Reader) error) error {
+	encoding := res.Header.Get("Content-Encoding")
+
+	reader, ok, err := decodeBody(res.Body, encoding)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return nil
+	}
+
+	return streamBody(res, reader, encoding, rewrite)
+}
+
+// streamBody replaces the body of response with the rewritten content of reader which encoded with the encoding
+func streamBody(res *http.Response, reader io.Reader, encoding string, rewrite func(w io.Writer, r io.Reader) error) error {
+	pr, pw := io.Pipe()
+	origin := res.Body
+
+	go func() {
+		encoder := encodeBody(pw, encoding)
+		w := bufio.NewWriterSize(&flushWriter{w: encoder}, rewriteChunkSize)
+
+		err := rewrite(w, reader)
+
+		if err == nil {
+			err = errors.WithStack(w.Flush())
+		}
+
+		if closeErr := encoder.Close(); err == nil {
+			err = errors.WithStack(closeErr)
+		}
+
+		_ = origin.Close()
+		_ = pw.CloseWithError(err)
+	}()
+
+	// the length is unknown until the body is rewritten
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Body = &pipeBody{PipeReader: pr, origin: origin}
+
+	return nil
+}
+
+// rewriteTextStream rewrites the text chunk by chunk.
+// a chunk is split after the last byte which can not be a part of url, so no url is split into two chunks.
+// if flush is true, eg. the body of unknown length which may be a long poll, the text is written and flushed once it is received.
+func rewriteTextStream(w io.Writer, r io.Reader, flush bool, rewrite func(s string) string) error {
+	buf := make([]byte, 0, rewriteChunkSize*2)
+	tmp := make([]byte, rewriteChunkSize)
+
+	for {
+		var (
+			n   int
+			err error
+		)
+
+		if flush {
+			n, err = r.Read(tmp)
+		} else {
+			// read a whole chunk, so that the boundary is searched once per chunk
+			n, err = io.ReadFull(r, tmp)
+		}
+
+		buf = append(buf, tmp[:n]...)
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if len(buf) > 0 {
+				if _, err := io.WriteString(w, rewrite(string(buf))); err != nil {
+					return errors.WithStack(err)
+				}
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		cut := bytes.LastIndexAny(buf, urlBoundaryBytes) + 1
+
+		// the tail which can not be the start of url is not kept for the next chunk
+		if flush && !mayBeURLPrefix(buf[cut:]) {
+			cut = len(buf)
+		}
+
+		if cut <= 0 {
+			if len(buf) < maxRewriteLookahead {
+				continue
+			}
+
+			cut = len(buf)
+		}
+
+		if _, err := io.WriteString(w, rewrite(string(buf[:cut]))); err != nil {
+			return errors.WithStack(err)
+		}
+
+		buf = append(buf[:0], buf[cut:]...)
+
+		if f, ok := w.(interface{ Flush() error }); ok && flush {
+			if err := f.Flush(); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+}
+
+// mayBeURLPrefix reports whether the text may be continued as an url, eg. 'htt', 'ws' or 'https://exa'
+func mayBeURLPrefix(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+
+	if bytes.ContainsAny(b, ":/") {
+		return true
+	}
+
+	s := strings.ToLower(string(b))
+
+	for _, scheme := range []string{"http", "https", "ws", "wss"} {
+		if strings.HasPrefix(scheme, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewriteEventStream rewrites the Server-Sent Events one by one, each event is flushed once it is rewritten
+func rewriteEventStream(w io.Writer, r io.Reader, rewrite func(s string) string) error {
+	reader := bufio.NewReader(r)
+	event := &strings.Builder{}
+
+	writeEvent := func() error {
+		if event.Len() == 0 {
+			return nil
+		}
+
+		if _, err := io.WriteString(w, rewrite(event.String())); err != nil {
+			return errors.WithStack(err)
+		}
+
+		event.Reset()
+
+		if f, ok := w.(interface{ Flush() error }); ok {
+			return errors.WithStack(f.Flush())
+		}
+
+		return nil
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		event.WriteString(line)
+
+		if err == io.EOF {
+			return writeEvent()
+		}
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		// the events are separated by a blank line
+		if line == "\n" || line == "\r\n" {
+			if err := writeEvent(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// oneByteReader returns the data byte by byte, to test the chunks split at any position
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	return o.r.Read(p[:1])
+}
+
+func Test_rewriteTextStream(t *testing.T) {
+	replace := func(s string) string {
+		return replaceHost(s, "example.com", "localhost:8080", false, false, nil)
+	}
+
+	padding := strings.Repeat("a", rewriteChunkSize-10)
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "small",
+			input: `fetch("http://example.com/api")`,
+			want:  `fetch("http://localhost:8080/api")`,
+		},
+		{
+			name:  "url across the chunk boundary",
+			input: padding + ` "http://example.com/api/data" ` + padding + ` 'http://example.com'`,
+			want:  padding + ` "http://localhost:8080/api/data" ` + padding + ` 'http://localhost:8080'`,
+		},
+		{
+			name:  "websocket url split in the scheme",
+			input: `new WebSocket("wss://example.com/ws")`,
+			want:  `new WebSocket("ws://localhost:8080/ws")`,
+		},
+		{
+			name:  "no boundary",
+			input: strings.Repeat("a", maxRewriteLookahead*2),
+			want:  strings.Repeat("a", maxRewriteLookahead*2),
+		},
+	}
+	for _, tt := range tests {
+		for _, flush := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s flush=%v", tt.name, flush), func(t *testing.T) {
+				buf := &bytes.Buffer{}
+
+				if err := rewriteTextStream(buf, &oneByteReader{strings.NewReader(tt.input)}, flush, replace); err != nil {
+					t.Fatal(err)
+				}
+
+				if got := buf.String(); got != tt.want {
+					t.Errorf("rewriteTextStream() = %.100q, want %.100q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func Test_streamRewrite(t *testing.T) {
+	raw := &bytes.Buffer{}
+	gz := gzip.NewWriter(raw)
+	_, _ = gz.Write([]byte("hello world"))
+	_ = gz.Close()
+
+	res := &http.Response{
+		Header:        http.Header{"Content-Encoding": []string{"gzip"}, "Content-Length": []string{"100"}},
+		ContentLength: int64(raw.Len()),
+		Body:          ioutil.NopCloser(raw),
+	}
+
+	err := streamRewrite(res, func(w io.Writer, r io.Reader) error {
+		return rewriteTextStream(w, r, false, strings.ToUpper)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.ContentLength != -1 || res.Header.Get("Content-Length") != "" {
+		t.Errorf("the length of rewritten body should be unknown")
+	}
+
+	reader, err := gzip.NewReader(res.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadAll(reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = res.Body.Close()
+
+	if string(body) != "HELLO WORLD" {
+		t.Errorf("streamRewrite() = %s, want %s", body, "HELLO WORLD")
+	}
+}
+
+func Test_rewriteEventStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []string
+	}{
+		{name: "events", stream: "data: a\n\nevent: b\ndata: b\r\n\r\n", want: []string{"DATA: A\n\n", "EVENT: B\nDATA: B\r\n\r\n"}},
+		{name: "incomplete event", stream: "data: a\n\ndata: b", want: []string{"DATA: A\n\n", "DATA: B"}},
+		{name: "empty", stream: "", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &eventWriter{}
+
+			if err := rewriteEventStream(w, strings.NewReader(tt.stream), strings.ToUpper); err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Join(w.events, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("rewriteEventStream() = %q, want %q", w.events, tt.want)
+			}
+		})
+	}
+}
+
+// eventWriter records the content between flushes
+type eventWriter struct {
+	buf    bytes.Buffer
+	events []string
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *eventWriter) Flush() error {
+	w.events = append(w.events, w.buf.String())
+	w.buf.Reset()
+
+	return nil
+}
+
+func TestProxyServer_eventStream(t *testing.T) {
+	done := make(chan struct{})
+
+	var backend *httptest.Server
+
+	backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		_, _ = w.Write([]byte("data: " + backend.URL + "/a\n\n"))
+		w.(http.Flusher).Flush()
+
+		// the stream is kept open until the client receives the first event
+		<-done
+	}))
+	defer backend.Close()
+	defer close(done)
+
+	target, _ := url.Parse(backend.URL)
+
+	server := NewProxyServer(&ProxyServerOptions{
+		Target:             target,
+		RewriteEventStream: true,
+	})
+	defer server.Close()
+
+	proxy := httptest.NewServer(http.HandlerFunc(server.Handler()))
+	defer proxy.Close()
+
+	res, err := http.Get(proxy.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer res.Body.Close()
+
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "data: " + proxy.URL + "/a\n"; line != want {
+		t.Errorf("event = %q, want %q", line, want)
+	}
+}
+
+func TestProxyServer_longPoll(t *testing.T) {
+	release := make(chan struct{})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"url":"` + "http://" + r.Host + `/a"}`))
+		w.(http.Flusher).Flush()
+
+		<-release
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	target, _ := url.Parse(backend.URL)
+
+	server := NewProxyServer(&ProxyServerOptions{Target: target})
+	defer server.Close()
+
+	proxy := httptest.NewServer(http.HandlerFunc(server.Handler()))
+	defer proxy.Close()
+
+	res, err := http.Get(proxy.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	want := `{"url":"http://` + strings.TrimPrefix(proxy.URL, "http://") + `/a"}`
+	got := make(chan string, 1)
+
+	// the chunk is received before the upstream closes
+	go func() {
+		buf := make([]byte, len(want))
+		n, _ := io.ReadFull(res.Body, buf)
+		got <- string(buf[:n])
+	}()
+
+	select {
+	case body := <-got:
+		if body != want {
+			t.Errorf("body = %s, want %s", body, want)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the chunk is not flushed before the upstream closes")
+	}
+}
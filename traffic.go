@@ -0,0 +1,266 @@
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var exchangeContextKey = &contextKey{"exchange"}
+
+// trafficObserver receives the exchanges after they finished
+type trafficObserver interface {
+	observe(e *exchange)
+}
+
+// capturedMessage is a request or response captured at some point of proxying
+type capturedMessage struct {
+	method string
+	url    string
+	proto  string
+	status int
+	header http.Header
+	body   *bodyCapture
+}
+
+// exchange is a request/response pair passed through the proxy server.
+// the request and response are captured before and after rewriting.
+type exchange struct {
+	startedAt           time.Time
+	upstreamSentAt      time.Time // the time of request started to send to upstream
+	upstreamWroteAt     time.Time // the time of request written to upstream completely
+	upstreamRespondedAt time.Time // the time of response header received from upstream
+	finishedAt          time.Time
+
+	request          capturedMessage // the request from client
+	upstreamRequest  *capturedMessage
+	upstreamResponse *capturedMessage
+	response         capturedMessage // the response to client
+	err              error
+
+	mu sync.Mutex
+}
+
+func getExchange(r *http.Request) *exchange {
+	if e, ok := r.Context().Value(exchangeContextKey).(*exchange); ok {
+		return e
+	}
+
+	return nil
+}
+
+// captureUpstreamRequest captures the rewritten request which is sent to upstream, and traces the time when it is written
+func (e *exchange) captureUpstreamRequest(req *http.Request, maxBodySize int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.upstreamSentAt = time.Now()
+	e.upstreamRequest = &capturedMessage{
+		method: req.Method,
+		url:    req.URL.String(),
+		proto:  req.Proto,
+		header: req.Header.Clone(),
+		body:   newBodyCapture(maxBodySize),
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &teeReadCloser{ReadCloser: req.Body, w: e.upstreamRequest.body}
+	}
+
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+
+			e.upstreamWroteAt = time.Now()
+		},
+	}
+
+	*req = *req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (e *exchange) captureUpstreamResponse(res *http.Response, maxBodySize int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.upstreamRespondedAt = time.Now()
+	e.upstreamResponse = &capturedMessage{
+		proto:  res.Proto,
+		status: res.StatusCode,
+		header: res.Header.Clone(),
+		body:   newBodyCapture(maxBodySize),
+	}
+
+	// the body of upgrade response must be kept as io.ReadWriteCloser
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		res.Body = &teeReadCloser{ReadCloser: res.Body, w: e.upstreamResponse.body}
+	}
+}
+
+func (e *exchange) captureError(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.err = err
+}
+
+// bodyCapture keeps the first bytes of a body
+type bodyCapture struct {
+	buf       bytes.Buffer
+	limit     int
+	size      int64
+	truncated bool
+}
+
+func newBodyCapture(limit int) *bodyCapture {
+	return &bodyCapture{limit: limit}
+}
+
+func (b *bodyCapture) Write(p []byte) (int, error) {
+	b.size += int64(len(p))
+
+	if remain := b.limit - b.buf.Len(); remain < len(p) {
+		b.truncated = true
+		if remain > 0 {
+			b.buf.Write(p[:remain])
+		}
+	} else {
+		b.buf.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// decoded returns the captured body which decoded by the content encoding.
+// it returns as much as possible if the body was truncated.
+func (b *bodyCapture) decoded(encoding string) []byte {
+	reader, ok, err := decodeBody(bytes.NewReader(b.buf.Bytes()), encoding)
+
+	if !ok || err != nil {
+		return b.buf.Bytes()
+	}
+
+	body, _ := ioutil.ReadAll(reader)
+
+	return body
+}
+
+type teeReadCloser struct {
+	io.ReadCloser
+	w io.Writer
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+
+	if n > 0 {
+		_, _ = t.w.Write(p[:n])
+	}
+
+	return n, err
+}
+
+// captureResponseWriter captures the response which is sent to client
+type captureResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   *bodyCapture
+}
+
+func (w *captureResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	_, _ = w.body.Write(p)
+
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *captureResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *captureResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		if w.status == 0 {
+			w.status = http.StatusSwitchingProtocols
+		}
+		return h.Hijack()
+	}
+
+	return nil, nil, errors.New("the response writer does not support hijack")
+}
+
+func (w *captureResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveWithCapture captures the exchange of request and notify the observers when it finished
+func (p *ProxyServer) serveWithCapture(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	e := &exchange{
+		startedAt: time.Now(),
+		request: capturedMessage{
+			method: r.Method,
+			url:    requestURL(r),
+			proto:  r.Proto,
+			header: r.Header.Clone(),
+			body:   newBodyCapture(p.maxCaptureBodySize),
+		},
+	}
+
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &teeReadCloser{ReadCloser: r.Body, w: e.request.body}
+	}
+
+	cw := &captureResponseWriter{
+		ResponseWriter: w,
+		body:           newBodyCapture(p.maxCaptureBodySize),
+	}
+
+	next(cw, r.WithContext(context.WithValue(r.Context(), exchangeContextKey, e)))
+
+	e.mu.Lock()
+	e.finishedAt = time.Now()
+	e.response = capturedMessage{
+		proto:  r.Proto,
+		status: cw.status,
+		header: cw.Header().Clone(),
+		body:   cw.body,
+	}
+	e.mu.Unlock()
+
+	for _, o := range p.observers {
+		o.observe(e)
+	}
+}
+
+// requestURL returns the absolute url of the request received by proxy server
+func requestURL(r *http.Request) string {
+	scheme := "http"
+
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
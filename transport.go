@@ -0,0 +1,292 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+)
+
+const (
+	ProtocolAuto  = "auto"  // HTTP/2 if the server supports it over TLS, otherwise HTTP/1.1
+	ProtocolHTTP1 = "http1" // HTTP/1.1 only
+	ProtocolHTTP2 = "http2" // HTTP/2 over TLS only
+	ProtocolH2C   = "h2c"   // HTTP/2 over cleartext TCP with prior knowledge
+)
+
+// the defaults of http.DefaultTransport
+const (
+	defaultDialTimeout         = 30 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// TransportOptions tunes the transport to upstream, the zero values keep the defaults of http.DefaultTransport
+type TransportOptions struct {
+	Certificates          []tls.Certificate // the client certificates presented to the upstream which requires mutual TLS
+	RootCAs               *x509.CertPool    // the CAs to verify the certificate of upstream. defaults to the CAs of system
+	InsecureSkipVerify    bool              // do not verify the certificate of upstream, eg. self-signed. it is insecure
+	ServerName            string            // the server name sent with SNI and verified, instead of the host of target
+	DialTimeout           time.Duration     // the timeout of connecting to upstream
+	TLSHandshakeTimeout   time.Duration     // the timeout of TLS handshake with upstream
+	ResponseHeaderTimeout time.Duration     // the timeout of waiting for the response headers after the request is written. HTTP/1.1 only
+	KeepAlive             time.Duration     // the interval of TCP keep-alive probes, negative disables them
+	DisableKeepAlives     bool              // do not reuse the connections to upstream
+	MaxIdleConns          int               // the maximum idle connections to all the upstreams
+	MaxIdleConnsPerHost   int               // the maximum idle connections to each upstream. defaults to 2
+	IdleConnTimeout       time.Duration     // close the idle connections after the duration
+	Proxy                 *url.URL          // the outbound proxy, 'http', 'https' or 'socks5' with the user info for auth. defaults to the env HTTP_PROXY and HTTPS_PROXY
+	NoProxy               []string          // the hosts connected directly, in the format of the env NO_PROXY, eg. '.example.com' or '10.0.0.0/8'
+}
+
+// tlsConfig returns the TLS config of upstream, it is nil if nothing to configure
+func (o *TransportOptions) tlsConfig() *tls.Config {
+	if o == nil || (len(o.Certificates) == 0 && o.RootCAs == nil && !o.InsecureSkipVerify && o.ServerName == "") {
+		return nil
+	}
+
+	return &tls.Config{
+		Certificates:       o.Certificates,
+		RootCAs:            o.RootCAs,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+		ServerName:         o.ServerName,
+	}
+}
+
+// dialer returns the dialer of TCP connections to upstream
+func (o *TransportOptions) dialer() *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   defaultDialTimeout,
+		KeepAlive: defaultKeepAlive,
+	}
+
+	if o == nil {
+		return dialer
+	}
+
+	if o.DialTimeout > 0 {
+		dialer.Timeout = o.DialTimeout
+	}
+
+	if o.KeepAlive != 0 {
+		dialer.KeepAlive = o.KeepAlive
+	}
+
+	return dialer
+}
+
+// tlsHandshakeTimeout returns the timeout of TLS handshake with upstream
+func (o *TransportOptions) tlsHandshakeTimeout() time.Duration {
+	if o == nil || o.TLSHandshakeTimeout <= 0 {
+		return defaultTLSHandshakeTimeout
+	}
+
+	return o.TLSHandshakeTimeout
+}
+
+// dialTLSContext returns the function which connects to the TLS upstream with the protocols, through the outbound proxy if any
+func (o *TransportOptions) dialTLSContext(nextProtos ...string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialContext := o.dialContext("https")
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialContext(ctx, network, addr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		config := o.tlsConfig()
+
+		if config == nil {
+			config = &tls.Config{}
+		}
+
+		config.NextProtos = nextProtos
+
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+
+		tlsConn := tls.Client(conn, config)
+
+		if err := o.handshake(ctx, tlsConn); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		return tlsConn, nil
+	}
+}
+
+// handshake does the TLS handshake in the timeout
+func (o *TransportOptions) handshake(ctx context.Context, conn *tls.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, o.tlsHandshakeTimeout())
+	defer cancel()
+
+	return errors.WithStack(conn.HandshakeContext(ctx))
+}
+
+// newTransport creates the transport to upstream with the protocol.
+// the upgrade requests, eg. WebSocket, are sent with HTTP/1.1 whatever the protocol is.
+func newTransport(options *ProxyServerOptions) http.RoundTripper {
+	var transport http.RoundTripper
+
+	o := options.Transport
+
+	if o != nil && o.InsecureSkipVerify {
+		log.Println("WARNING: the certificate of upstream is NOT verified, the connection is open to the man-in-the-middle attack")
+	}
+
+	switch options.UpstreamProtocol {
+	case "", ProtocolAuto:
+		transport = newHTTPTransport(o)
+	case ProtocolHTTP1:
+		t := newHTTPTransport(o)
+		t.DialTLSContext = o.dialTLSContext("http/1.1")
+		t.ForceAttemptHTTP2 = false
+		// a non-nil empty map disables HTTP/2
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		transport = t
+	case ProtocolHTTP2:
+		transport = newHTTP2Transport(o)
+	case ProtocolH2C:
+		transport = newH2CTransport(o)
+	default:
+		log.Printf("ignore the invalid upstream protocol '%s'\n", options.UpstreamProtocol)
+
+		transport = newHTTPTransport(o)
+	}
+
+	return &upstreamTransport{
+		transport: transport,
+		upgrade:   newUpgradeTransport(o),
+		h2:        newHTTP2Transport(o),
+		h2c:       newH2CTransport(o),
+	}
+}
+
+func newHTTPTransport(o *TransportOptions) *http.Transport {
+	proxyFunc := o.proxyFunc()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// the https upstream is connected through the tunnel of dialTLSContext, so a failed CONNECT is a dial error
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if req.URL.Scheme == "https" {
+			return nil, nil
+		}
+
+		return proxyFunc(req.URL)
+	}
+	transport.DialContext = o.dialer().DialContext
+	transport.DialTLSContext = o.dialTLSContext(http2.NextProtoTLS, "http/1.1")
+	transport.TLSClientConfig = o.tlsConfig()
+	transport.TLSHandshakeTimeout = o.tlsHandshakeTimeout()
+
+	if o == nil {
+		return transport
+	}
+
+	transport.ResponseHeaderTimeout = o.ResponseHeaderTimeout
+	transport.DisableKeepAlives = o.DisableKeepAlives
+
+	if o.MaxIdleConns > 0 {
+		transport.MaxIdleConns = o.MaxIdleConns
+	}
+
+	if o.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	}
+
+	if o.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = o.IdleConnTimeout
+	}
+
+	return transport
+}
+
+// newUpgradeTransport creates the transport of HTTP/1.1 for the upgrade requests.
+// the HTTP proxies may not forward the upgrade requests, so they are sent through a tunnel of the outbound proxy.
+func newUpgradeTransport(o *TransportOptions) *http.Transport {
+	transport := newHTTPTransport(o)
+	transport.Proxy = nil
+	transport.DialContext = o.dialContext("http")
+	transport.DialTLSContext = o.dialTLSContext("http/1.1")
+	transport.ForceAttemptHTTP2 = false
+	transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+
+	return transport
+}
+
+// newHTTP2Transport creates the transport of HTTP/2 over TLS, the connections are multiplexed so the settings of idle connections do not apply
+func newHTTP2Transport(o *TransportOptions) *http2.Transport {
+	dialContext := o.dialContext("https")
+
+	return &http2.Transport{
+		TLSClientConfig: o.tlsConfig(),
+		// the config is prepared by http2.Transport with the protocol 'h2' and the server name
+		DialTLSContext: func(ctx context.Context, network, addr string, config *tls.Config) (net.Conn, error) {
+			conn, err := dialContext(ctx, network, addr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			tlsConn := tls.Client(conn, config)
+
+			if err := o.handshake(ctx, tlsConn); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+
+			if p := tlsConn.ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+				_ = conn.Close()
+				return nil, errors.Errorf("the upstream '%s' does not support HTTP/2, the protocol '%s' is negotiated", addr, p)
+			}
+
+			return tlsConn, nil
+		},
+	}
+}
+
+func newH2CTransport(o *TransportOptions) *http2.Transport {
+	dialContext := o.dialContext("http")
+
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialContext(ctx, network, addr)
+		},
+	}
+}
+
+// upstreamTransport sends the upgrade requests with HTTP/1.1, and the gRPC requests with HTTP/2.
+// h2c is used for the cleartext gRPC upstream.
+type upstreamTransport struct {
+	transport http.RoundTripper
+	upgrade   http.RoundTripper
+	h2        http.RoundTripper
+	h2c       http.RoundTripper
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if headerContainsToken(req.Header, "Connection", "upgrade") {
+		return t.upgrade.RoundTrip(req)
+	}
+
+	if !isGRPC(req.Header.Get("Content-Type")) {
+		return t.transport.RoundTrip(req)
+	}
+
+	if req.URL.Scheme == "https" {
+		return t.h2.RoundTrip(req)
+	}
+
+	return t.h2c.RoundTrip(req)
+}
@@ -0,0 +1,219 @@
+package forward
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestProxyServer_upstreamProtocol(t *testing.T) {
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	tlsBackend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	tlsBackend.EnableHTTP2 = true
+	tlsBackend.StartTLS()
+	defer tlsBackend.Close()
+
+	target, _ := url.Parse(backend.URL)
+	tlsTarget, _ := url.Parse(tlsBackend.URL)
+
+	tests := []struct {
+		name     string
+		protocol string
+		target   *url.URL
+		want     string
+	}{
+		{name: "auto", protocol: ProtocolAuto, target: target, want: "HTTP/1.1"},
+		{name: "http1", protocol: ProtocolHTTP1, target: target, want: "HTTP/1.1"},
+		{name: "h2c", protocol: ProtocolH2C, target: target, want: "HTTP/2.0"},
+		{name: "auto over tls", protocol: ProtocolAuto, target: tlsTarget, want: "HTTP/2.0"},
+		{name: "http1 over tls", protocol: ProtocolHTTP1, target: tlsTarget, want: "HTTP/1.1"},
+		{name: "http2 over tls", protocol: ProtocolHTTP2, target: tlsTarget, want: "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewProxyServer(&ProxyServerOptions{
+				Target:           tt.target,
+				UpstreamProtocol: tt.protocol,
+				Transport:        &TransportOptions{InsecureSkipVerify: true},
+			})
+			defer server.Close()
+
+			proxy := httptest.NewServer(http.HandlerFunc(server.Handler()))
+			defer proxy.Close()
+
+			res, err := http.Get(proxy.URL)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+
+			if string(body) != tt.want {
+				t.Errorf("protocol = %s, want %s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyServer_mutualTLS(t *testing.T) {
+	ca, err := NewLocalCA(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientCert, err := ca.issue("client.test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Client-Cert-Subject")))
+	}))
+	backend.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	backend.StartTLS()
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+
+	upstreamCAs := x509.NewCertPool()
+	upstreamCAs.AddCert(backend.Certificate())
+
+	server := NewProxyServer(&ProxyServerOptions{
+		Target: target,
+		Transport: &TransportOptions{
+			Certificates: []tls.Certificate{*clientCert},
+			RootCAs:      upstreamCAs,
+		},
+	})
+	defer server.Close()
+
+	proxy := httptest.NewUnstartedServer(http.HandlerFunc(server.Handler()))
+	proxy.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	proxy.StartTLS()
+	defer proxy.Close()
+
+	proxyCAs := x509.NewCertPool()
+	proxyCAs.AddCert(proxy.Certificate())
+
+	tests := []struct {
+		name       string
+		cert       *tls.Certificate
+		wantErr    bool
+		wantHeader string
+	}{
+		{name: "verified", cert: clientCert, wantHeader: "CN=client.test,O=forward-cli"},
+		{name: "no certificate", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &tls.Config{RootCAs: proxyCAs}
+
+			if tt.cert != nil {
+				config.Certificates = []tls.Certificate{*tt.cert}
+			}
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+
+			req, _ := http.NewRequest(http.MethodGet, proxy.URL, nil)
+			// the header from client can not be trusted
+			req.Header.Set("X-Client-Cert-Subject", "CN=admin")
+
+			res, err := client.Do(req)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", res.StatusCode, body)
+			}
+
+			if string(body) != tt.wantHeader {
+				t.Errorf("subject = %s, want %s", body, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestProxyServer_transportOptions(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(backend.Certificate())
+
+	tests := []struct {
+		name       string
+		options    *TransportOptions
+		path       string
+		wantStatus int
+	}{
+		{name: "untrusted certificate", options: nil, wantStatus: http.StatusInternalServerError},
+		{name: "insecure skip verify", options: &TransportOptions{InsecureSkipVerify: true}, wantStatus: http.StatusOK},
+		// the certificate of httptest is issued for 'example.com'
+		{name: "server name", options: &TransportOptions{RootCAs: roots, ServerName: "example.com"}, wantStatus: http.StatusOK},
+		{name: "mismatched server name", options: &TransportOptions{RootCAs: roots, ServerName: "other.test"}, wantStatus: http.StatusInternalServerError},
+		{name: "response header timeout", options: &TransportOptions{RootCAs: roots, ResponseHeaderTimeout: 50 * time.Millisecond}, path: "/slow", wantStatus: http.StatusInternalServerError},
+		{name: "disable keep alives", options: &TransportOptions{RootCAs: roots, DisableKeepAlives: true, DialTimeout: time.Second}, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewProxyServer(&ProxyServerOptions{
+				Target:    target,
+				Transport: tt.options,
+			})
+			defer server.Close()
+
+			proxy := httptest.NewServer(http.HandlerFunc(server.Handler()))
+			defer proxy.Close()
+
+			res, err := http.Get(proxy.URL + tt.path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res.Body.Close()
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package forward
 import (
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
@@ -166,3 +167,72 @@ func replaceHost(content, oldHost, newHost string, useSSL bool, proxyExternal bo
 
 	return newContent
 }
+
+// addHeaders adds the global headers and the headers of route to h, the headers of route override the global ones with the same key
+func addHeaders(h http.Header, global http.Header, route http.Header) {
+	for k := range global {
+		if len(route.Values(k)) == 0 {
+			h.Add(k, global.Get(k))
+		}
+	}
+
+	for k := range route {
+		h.Add(k, route.Get(k))
+	}
+}
+
+// matchHostName reports whether the host name matches the pattern.
+// the pattern can start with '*.' to match any sub domain.
+func matchHostName(pattern, hostName string) bool {
+	pattern = strings.ToLower(pattern)
+	hostName = strings.ToLower(hostName)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(hostName, pattern[1:])
+	}
+
+	return pattern == hostName
+}
+
+// hasPathPrefix reports whether the path starts with the prefix at a segment boundary.
+// eg. '/api' matches '/api' and '/api/user' but not '/apis'.
+func hasPathPrefix(p, prefix string) bool {
+	if !strings.HasPrefix(p, prefix) {
+		return false
+	}
+
+	return len(p) == len(prefix) || strings.HasSuffix(prefix, "/") || p[len(prefix)] == '/'
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// joinURLPath join the path of target and request, same as httputil.NewSingleHostReverseProxy does
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+
+	aslash := strings.HasSuffix(apath, "/")
+	bslash := strings.HasPrefix(bpath, "/")
+
+	switch {
+	case aslash && bslash:
+		return a.Path + b.Path[1:], apath + bpath[1:]
+	case !aslash && !bslash:
+		return a.Path + "/" + b.Path, apath + "/" + bpath
+	}
+	return a.Path + b.Path, apath + bpath
+}
@@ -159,3 +159,46 @@ google.jslm=x?2:1;})();google.x(null, function(){(function(){(function(){google.
 		})
 	}
 }
+
+func Test_hasPathPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		want   bool
+	}{
+		{path: "/api", prefix: "/api", want: true},
+		{path: "/api/user", prefix: "/api", want: true},
+		{path: "/apis", prefix: "/api", want: false},
+		{path: "/api/user", prefix: "/api/", want: true},
+		{path: "/", prefix: "/", want: true},
+		{path: "/static", prefix: "/api", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.prefix, func(t *testing.T) {
+			if got := hasPathPrefix(tt.path, tt.prefix); got != tt.want {
+				t.Errorf("hasPathPrefix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchHostName(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		hostName string
+		want     bool
+	}{
+		{pattern: "example.com", hostName: "example.com", want: true},
+		{pattern: "example.com", hostName: "EXAMPLE.com", want: true},
+		{pattern: "example.com", hostName: "api.example.com", want: false},
+		{pattern: "*.example.com", hostName: "api.example.com", want: true},
+		{pattern: "*.example.com", hostName: "example.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.hostName, func(t *testing.T) {
+			if got := matchHostName(tt.pattern, tt.hostName); got != tt.want {
+				t.Errorf("matchHostName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package forward
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWebSocketLogMaxPayloadSize = 4096
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+var webSocketConnID uint64
+
+// isWebSocket reports whether the request is a WebSocket handshake
+func isWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") && headerContainsToken(r.Header, "Connection", "upgrade")
+}
+
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, v := range header.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// httpScheme converts the scheme of WebSocket to HTTP, the handshake is sent over HTTP
+func httpScheme(scheme string) string {
+	switch strings.ToLower(scheme) {
+	case "ws":
+		return "http"
+	case "wss":
+		return "https"
+	default:
+		return scheme
+	}
+}
+
+// WebSocketLogger writes the frames of WebSocket connections to a file, one JSON object per line
+type WebSocketLogger struct {
+	file           *os.File
+	maxPayloadSize int
+	mu             sync.Mutex
+}
+
+type webSocketLogEntry struct {
+	Time      string `json:"time"`
+	Conn      uint64 `json:"conn"`
+	URL       string `json:"url"`
+	Direction string `json:"direction"` // '>' is sent to upstream, '<' is received from upstream
+	Opcode    string `json:"opcode"`
+	Length    uint64 `json:"length"`
+	Payload   string `json:"payload,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// NewWebSocketLogger creates the log file. the payload larger than maxPayloadSize will be truncated.
+func NewWebSocketLogger(filePath string, maxPayloadSize int) (*WebSocketLogger, error) {
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if maxPayloadSize <= 0 {
+		maxPayloadSize = defaultWebSocketLogMaxPayloadSize
+	}
+
+	return &WebSocketLogger{file: f, maxPayloadSize: maxPayloadSize}, nil
+}
+
+// Close closes the log file
+func (l *WebSocketLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return errors.WithStack(l.file.Close())
+}
+
+func (l *WebSocketLogger) log(c *webSocketConn, direction string, f *wsFrame) {
+	entry := &webSocketLogEntry{
+		Time:      time.Now().Format(time.RFC3339Nano),
+		Conn:      c.id,
+		URL:       c.url,
+		Direction: direction,
+		Opcode:    wsOpcodeName(f.opcode),
+		Length:    f.length,
+		Truncated: uint64(len(f.payload)) < f.length,
+	}
+
+	if f.opcode == wsOpText || f.opcode == wsOpClose || utf8.Valid(f.payload) {
+		entry.Payload = string(f.payload)
+	} else {
+		entry.Payload = base64.StdEncoding.EncodeToString(f.payload)
+		entry.Encoding = "base64"
+	}
+
+	b, err := json.Marshal(entry)
+
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(append(b, '\n')); err != nil {
+		log.Printf("failed to write WebSocket log: %+v\n", errors.WithStack(err))
+	}
+}
+
+func wsOpcodeName(opcode byte) string {
+	switch opcode {
+	case wsOpContinuation:
+		return "continuation"
+	case wsOpText:
+		return "text"
+	case wsOpBinary:
+		return "binary"
+	case wsOpClose:
+		return "close"
+	case wsOpPing:
+		return "ping"
+	case wsOpPong:
+		return "pong"
+	default:
+		return "unknown"
+	}
+}
+
+// wsFrame is the header and the captured payload of a frame
+type wsFrame struct {
+	opcode  byte
+	length  uint64
+	payload []byte // the unmasked payload, it is truncated by the limit
+}
+
+// copyFrames copies the frames from r to w, calls onFrame for each frame.
+// the frame is written while holding the lock, so that the control frames can be inserted between frames.
+func copyFrames(w io.Writer, r io.Reader, mu *sync.Mutex, captureSize int, onFrame func(f *wsFrame)) error {
+	header := make([]byte, 14)
+	buf := make([]byte, 32*1024)
+
+	for {
+		if _, err := io.ReadFull(r, header[:2]); err != nil {
+			return err
+		}
+
+		n := 2
+		masked := header[1]&0x80 != 0
+		length := uint64(header[1] & 0x7f)
+
+		switch length {
+		case 126:
+			if _, err := io.ReadFull(r, header[n:n+2]); err != nil {
+				return err
+			}
+
+			length = uint64(binary.BigEndian.Uint16(header[n : n+2]))
+			n += 2
+		case 127:
+			if _, err := io.ReadFull(r, header[n:n+8]); err != nil {
+				return err
+			}
+
+			length = binary.BigEndian.Uint64(header[n : n+8])
+			n += 8
+		}
+
+		var mask []byte
+
+		if masked {
+			if _, err := io.ReadFull(r, header[n:n+4]); err != nil {
+				return err
+			}
+
+			mask = header[n : n+4]
+			n += 4
+		}
+
+		f := &wsFrame{opcode: header[0] & 0x0f, length: length}
+
+		err := func() error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if _, err := w.Write(header[:n]); err != nil {
+				return err
+			}
+
+			for remain, offset := length, uint64(0); remain > 0; {
+				size := uint64(len(buf))
+
+				if remain < size {
+					size = remain
+				}
+
+				if _, err := io.ReadFull(r, buf[:size]); err != nil {
+					return err
+				}
+
+				if _, err := w.Write(buf[:size]); err != nil {
+					return err
+				}
+
+				if capture := captureSize - len(f.payload); capture > 0 {
+					if uint64(capture) > size {
+						capture = int(size)
+					}
+
+					for i := 0; i < capture; i++ {
+						b := buf[i]
+
+						if mask != nil {
+							b ^= mask[(offset+uint64(i))%4]
+						}
+
+						f.payload = append(f.payload, b)
+					}
+				}
+
+				remain -= size
+				offset += size
+			}
+
+			return nil
+		}()
+
+		if err != nil {
+			return err
+		}
+
+		onFrame(f)
+	}
+}
+
+// webSocketConn wraps the upstream connection of an upgraded request.
+// the reverse proxy reads the frames to client from it, and writes the frames from client to it.
+type webSocketConn struct {
+	upstream io.ReadWriteCloser
+	id       uint64
+	url      string
+	server   *ProxyServer
+
+	// the frames from upstream and the pings are sent to client through the pipe
+	toClient     *io.PipeReader
+	toClientW    *io.PipeWriter
+	toClientMu   sync.Mutex
+	fromClientW  *io.PipeWriter
+	fromClientMu sync.Mutex
+
+	openedAt     time.Time
+	lastActiveAt int64 // unix nano
+	framesIn     int64 // the frames received from upstream
+	framesOut    int64 // the frames sent to upstream
+	closeOnce    sync.Once
+	done         chan struct{}
+}
+
+func (p *ProxyServer) wrapWebSocket(res *http.Response) {
+	upstream, ok := res.Body.(io.ReadWriteCloser)
+
+	if !ok {
+		return
+	}
+
+	c := &webSocketConn{
+		upstream:     upstream,
+		id:           atomic.AddUint64(&webSocketConnID, 1),
+		url:          res.Request.URL.String(),
+		server:       p,
+		openedAt:     time.Now(),
+		lastActiveAt: time.Now().UnixNano(),
+		done:         make(chan struct{}),
+	}
+
+	c.toClient, c.toClientW = io.Pipe()
+
+	fromClient, fromClientW := io.Pipe()
+	c.fromClientW = fromClientW
+
+	capture := 0
+
+	if p.WebSocketLogger != nil {
+		capture = p.WebSocketLogger.maxPayloadSize
+	}
+
+	go func() {
+		err := copyFrames(c.toClientW, upstream, &c.toClientMu, capture, func(f *wsFrame) {
+			atomic.AddInt64(&c.framesIn, 1)
+			c.active(f)
+
+			if p.WebSocketLogger != nil {
+				p.WebSocketLogger.log(c, "<", f)
+			}
+		})
+
+		_ = c.toClientW.CloseWithError(err)
+		_ = c.Close()
+	}()
+
+	go func() {
+		err := copyFrames(upstream, fromClient, &c.fromClientMu, capture, func(f *wsFrame) {
+			atomic.AddInt64(&c.framesOut, 1)
+			c.active(f)
+
+			if p.WebSocketLogger != nil {
+				p.WebSocketLogger.log(c, ">", f)
+			}
+		})
+
+		_ = fromClient.CloseWithError(err)
+		_ = c.Close()
+	}()
+
+	if p.WebSocketPingInterval > 0 || p.WebSocketIdleTimeout > 0 {
+		go c.keepalive()
+	}
+
+	log.Printf("WebSocket #%d opened: %s\n", c.id, c.url)
+
+	res.Body = c
+}
+
+// active records the time of data frame, the control frames, eg. ping and pong, do not keep the connection alive
+func (c *webSocketConn) active(f *wsFrame) {
+	if f.opcode >= wsOpClose {
+		return
+	}
+
+	atomic.StoreInt64(&c.lastActiveAt, time.Now().UnixNano())
+}
+
+// keepalive sends pings to client, and closes the connection if there is no frame in the idle timeout
+func (c *webSocketConn) keepalive() {
+	interval := c.server.WebSocketPingInterval
+
+	if interval <= 0 || (c.server.WebSocketIdleTimeout > 0 && c.server.WebSocketIdleTimeout < interval) {
+		interval = c.server.WebSocketIdleTimeout
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastPingAt := time.Now()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			idle := now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastActiveAt)))
+
+			if c.server.WebSocketIdleTimeout > 0 && idle >= c.server.WebSocketIdleTimeout {
+				log.Printf("WebSocket #%d is idle for %s, closing\n", c.id, idle.Round(time.Millisecond))
+				_ = c.Close()
+				return
+			}
+
+			if c.server.WebSocketPingInterval > 0 && now.Sub(lastPingAt) >= c.server.WebSocketPingInterval {
+				lastPingAt = now
+
+				// the frame from server to client is not masked
+				c.toClientMu.Lock()
+				_, err := c.toClientW.Write([]byte{0x80 | wsOpPing, 0})
+				c.toClientMu.Unlock()
+
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *webSocketConn) Read(p []byte) (int, error) {
+	return c.toClient.Read(p)
+}
+
+func (c *webSocketConn) Write(p []byte) (int, error) {
+	return c.fromClientW.Write(p)
+}
+
+func (c *webSocketConn) Close() error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.upstream.Close()
+		_ = c.toClientW.Close()
+		_ = c.fromClientW.Close()
+
+		log.Printf("WebSocket #%d closed after %s, %d frames sent, %d frames received: %s\n", c.id, time.Since(c.openedAt).Round(time.Millisecond), atomic.LoadInt64(&c.framesOut), atomic.LoadInt64(&c.framesIn), c.url)
+	})
+
+	return errors.WithStack(err)
+}
@@ -0,0 +1,192 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func Test_copyFrames(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), 200)
+
+	tests := []struct {
+		name        string
+		frames      []byte
+		captureSize int
+		want        []wsFrame
+	}{
+		{
+			name:        "unmasked",
+			frames:      []byte{0x81, 0x02, 'h', 'i', 0x89, 0x00},
+			captureSize: 10,
+			want:        []wsFrame{{opcode: wsOpText, length: 2, payload: []byte("hi")}, {opcode: wsOpPing, length: 0}},
+		},
+		{
+			name:        "masked",
+			frames:      []byte{0x82, 0x82, 0x01, 0x02, 0x03, 0x04, 'h' ^ 0x01, 'i' ^ 0x02},
+			captureSize: 10,
+			want:        []wsFrame{{opcode: wsOpBinary, length: 2, payload: []byte("hi")}},
+		},
+		{
+			name:        "extended length and truncated",
+			frames:      append([]byte{0x81, 126, 0x00, 200}, long...),
+			captureSize: 3,
+			want:        []wsFrame{{opcode: wsOpText, length: 200, payload: []byte("aaa")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &bytes.Buffer{}
+			var got []wsFrame
+
+			_ = copyFrames(w, bytes.NewReader(tt.frames), &sync.Mutex{}, tt.captureSize, func(f *wsFrame) {
+				got = append(got, *f)
+			})
+
+			if !bytes.Equal(w.Bytes(), tt.frames) {
+				t.Errorf("copyFrames() wrote %v, want %v", w.Bytes(), tt.frames)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("copyFrames() got %d frames, want %d", len(got), len(tt.want))
+			}
+
+			for i := range got {
+				if got[i].opcode != tt.want[i].opcode || got[i].length != tt.want[i].length || !bytes.Equal(got[i].payload, tt.want[i].payload) {
+					t.Errorf("copyFrames() frame %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProxyServer_webSocket(t *testing.T) {
+	var origin string
+
+	echo := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		origin = ws.Request().Header.Get("Origin")
+
+		var message string
+
+		for websocket.Message.Receive(ws, &message) == nil {
+			_ = websocket.Message.Send(ws, "echo: "+message)
+		}
+	}))
+	defer echo.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("http"))
+	}))
+	defer backend.Close()
+
+	logFile := filepath.Join(t.TempDir(), "ws.log")
+	logger, err := NewWebSocketLogger(logFile, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	target, _ := url.Parse(backend.URL)
+	echoTarget, _ := url.Parse(echo.URL)
+
+	server := NewProxyServer(&ProxyServerOptions{
+		Target:                target,
+		Routes:                []*Route{{PathPrefix: "/ws", Target: echoTarget, WebSocket: true}},
+		WebSocketLogger:       logger,
+		WebSocketIdleTimeout:  300 * time.Millisecond,
+		WebSocketPingInterval: 50 * time.Millisecond,
+		// the upgraded connection is not rewritten by the rule which matches any response
+		ReplaceRules: []*ReplaceRule{{Find: "echo", Replace: "ECHO"}},
+	})
+	defer server.Close()
+
+	proxy := httptest.NewServer(http.HandlerFunc(server.Handler()))
+	defer proxy.Close()
+
+	// the plain request is not matched by the WebSocket route
+	res, err := http.Get(proxy.URL + "/ws")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if string(body) != "http" {
+		t.Errorf("body = %s, want http", body)
+	}
+
+	ws, err := websocket.Dial(strings.Replace(proxy.URL, "http://", "ws://", 1)+"/ws", "", proxy.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	if err := websocket.Message.Send(ws, "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	var message string
+
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if err := websocket.Message.Receive(ws, &message); err != nil {
+		t.Fatal(err)
+	}
+
+	if message != "echo: hello" {
+		t.Errorf("message = %s, want 'echo: hello'", message)
+	}
+
+	if origin != echo.URL {
+		t.Errorf("Origin = %s, want %s", origin, echo.URL)
+	}
+
+	// the pings are answered by client, but the connection is closed when no message is sent
+	start := time.Now()
+
+	if err := websocket.Message.Receive(ws, &message); err == nil {
+		t.Errorf("the idle connection is not closed")
+	}
+
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("the idle connection is closed after %s", time.Since(start))
+	}
+
+	b, err := ioutil.ReadFile(logFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var frames []string
+
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		entry := &webSocketLogEntry{}
+
+		if err := json.Unmarshal([]byte(line), entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if entry.Opcode == "text" {
+			frames = append(frames, entry.Direction+entry.Payload)
+		}
+	}
+
+	if strings.Join(frames, ",") != ">hello,<echo: hello" {
+		t.Errorf("logged frames = %v", frames)
+	}
+}